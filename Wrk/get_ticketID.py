@@ -1,13 +1,34 @@
 # fetch_ticket.py
 # -*- coding: utf-8 -*-
 
+import hashlib
 import requests
 import time
 
+URL = "http://47.92.151.211:9090/graphql"
+
+
+def solve(challenge, difficulty):
+    # 找到 nonce，使 sha256(challenge + ":" + nonce) 的前 difficulty 位为 0
+    nonce = 0
+    while True:
+        digest = hashlib.sha256(f"{challenge}:{nonce}".encode()).digest()
+        if int.from_bytes(digest, "big") >> (256 - difficulty) == 0:
+            return str(nonce)
+        nonce += 1
+
+
 def fetch_ticket():
+    # 服务端开启 challenge 后，需要先解出工作量证明才能拿到票据
+    response = requests.post(URL, json={"query": "{ getChallenge { challenge difficulty } }"})
+    c = response.json()["data"]["getChallenge"]
+    nonce = solve(c["challenge"], c["difficulty"])
     response = requests.post(
-        "http://47.92.151.211:9090/graphql",
-        json={"query": "{ getCurrentTicket { ticketID } }"}
+        URL,
+        json={
+            "query": "query($c: String, $n: String) { getCurrentTicket(challenge: $c, nonce: $n) { ticketID } }",
+            "variables": {"c": c["challenge"], "n": nonce},
+        },
     )
     data = response.json()
     ticket_id = data["data"]["getCurrentTicket"]["ticketID"]
@@ -18,4 +39,3 @@ if __name__ == "__main__":
     while True:
         fetch_ticket()
         time.sleep(18)  # 更新频率稍低于票据更新频率，这里假设票据每20秒更新一次
-
@@ -0,0 +1,117 @@
+package admin
+
+import (
+	"VoteMe/auth"
+	"VoteMe/config"
+	"VoteMe/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrForbidden 当前角色没有权限执行该操作
+var ErrForbidden = errors.New("forbidden")
+
+// Principal 通过认证的管理员
+type Principal struct {
+	Subject    string
+	Role       Role
+	RemoteAddr string
+}
+
+type ctxKey int
+
+const principalKey ctxKey = iota
+
+var (
+	verifier  atomic.Pointer[auth.Verifier]
+	roleClaim string
+)
+
+// Setup 按配置创建管理接口的 JWT 校验器，管理接口所有请求都必须认证
+func Setup(conf config.AdminConf) {
+	roleClaim = conf.RoleClaim
+	verifier.Store(auth.NewVerifier(conf.JWTConf))
+	log.WithFields(log.Fields{"addr": conf.Addr, "issuer": conf.Issuer, "jwks": conf.JWKSURL}).Info("admin api enabled")
+}
+
+// ReloadOnSecretChange 密钥文件更新后使用新的密钥
+func ReloadOnSecretChange() {
+	config.OnSecretChange(func(field string, value config.Secret) {
+		if v := verifier.Load(); field == "admin.hmac_secret" && v != nil {
+			v.SetHMACSecret(value)
+		}
+	})
+}
+
+// FromContext 返回当前请求的管理员
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// WithPrincipal 在 ctx 中记录管理员
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey, p)
+	return logging.WithFields(ctx, log.Fields{"admin": p.Subject, "role": p.Role.String()})
+}
+
+// Require 检查当前管理员是否拥有 role 的权限
+func Require(ctx context.Context, role Role) (Principal, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return p, auth.ErrUnauthenticated
+	}
+	if !p.Role.Allows(role) {
+		return p, fmt.Errorf("%w: requires %s role, got %s", ErrForbidden, role, p.Role)
+	}
+	return p, nil
+}
+
+// Middleware 校验 Authorization: Bearer 中的 JWT 和角色，没有携带、校验失败返回 401，没有角色返回 403
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := verifier.Load()
+		raw, ok := auth.BearerToken(r.Header.Get("Authorization"))
+		if v == nil || !ok {
+			auth.WriteUnauthorized(w, "authentication required")
+			return
+		}
+		subject, claims, err := v.Verify(r.Context(), raw)
+		if err != nil {
+			logging.FromContext(r.Context()).WithError(err).Warn("reject invalid admin token")
+			auth.WriteUnauthorized(w, "invalid token")
+			return
+		}
+		role := roleFromClaim(claims[roleClaim])
+		if role == RoleNone {
+			logging.FromContext(r.Context()).WithField("admin", subject).Warn("reject admin token without role")
+			writeForbidden(w, "no admin role")
+			return
+		}
+		remote, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remote = r.RemoteAddr
+		}
+		p := Principal{Subject: subject, Role: role, RemoteAddr: remote}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// writeForbidden 返回 403，响应体与 GraphQL 错误格式一致
+func writeForbidden(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": nil,
+		"errors": []map[string]interface{}{{
+			"message":    message,
+			"extensions": map[string]interface{}{"code": "FORBIDDEN"},
+		}},
+	})
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"VoteMe/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestRoleFromClaim(t *testing.T) {
+	assert.Equal(t, RoleOperator, roleFromClaim("Operator"))
+	assert.Equal(t, RoleAdmin, roleFromClaim([]interface{}{"viewer", "admin", 1}), "数组时取最高的角色")
+	assert.Equal(t, RoleNone, roleFromClaim("root"))
+	assert.Equal(t, RoleNone, roleFromClaim(nil))
+	assert.True(t, RoleAdmin.Allows(RoleOperator))
+	assert.False(t, RoleViewer.Allows(RoleOperator))
+}
+
+func TestMiddleware(t *testing.T) {
+	Setup(config.AdminConf{Enabled: true, JWTConf: config.JWTConf{HMACSecret: testSecret, SubjectClaim: "sub"}, RoleClaim: "role"})
+	defer verifier.Store(nil)
+
+	serve := func(claims jwt.MapClaims) (int, Principal) {
+		var p Principal
+		h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, _ = FromContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+		if claims != nil {
+			claims["exp"] = time.Now().Add(time.Hour).Unix()
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+			assert.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code, p
+	}
+
+	code, p := serve(jwt.MapClaims{"sub": "ops", "role": "operator"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Subject: "ops", Role: RoleOperator, RemoteAddr: "192.0.2.1"}, p)
+
+	code, _ = serve(jwt.MapClaims{"sub": "ops"})
+	assert.Equal(t, http.StatusForbidden, code, "没有角色")
+	code, _ = serve(nil)
+	assert.Equal(t, http.StatusUnauthorized, code, "管理接口不允许匿名访问")
+
+	ctx := WithPrincipal(httptest.NewRequest(http.MethodGet, "/", nil).Context(), Principal{Subject: "v", Role: RoleViewer})
+	_, err := Require(ctx, RoleViewer)
+	assert.NoError(t, err)
+	_, err = Require(ctx, RoleAdmin)
+	assert.ErrorIs(t, err, ErrForbidden)
+}
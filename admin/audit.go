@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"VoteMe/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// 审计日志中的操作结果
+const (
+	ResultStarted = "started" // 已记录，正在执行；进程在执行期间退出时保留该状态
+	ResultOK      = "ok"
+	ResultError   = "error"
+	ResultDenied  = "denied" // 角色权限不足
+)
+
+// Migrate 创建审计日志表
+func Migrate(ctx context.Context) error {
+	return db.GetDB().WithContext(ctx).AutoMigrate(&model.AuditLog{})
+}
+
+// Audit 检查权限并执行管理操作，执行前先写入审计日志，写入失败时不执行操作，执行后更新结果
+// 权限不足的尝试同样会记录
+func Audit(ctx context.Context, role Role, action string, args map[string]interface{}, run func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	p, err := Require(ctx, role)
+	if errors.Is(err, ErrForbidden) {
+		entry := newEntry(ctx, p, action, args)
+		entry.Result, entry.Error = ResultDenied, err.Error()
+		if err := insert(ctx, entry); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("action", action).Error("write audit log failed")
+		}
+		metrics.AdminActions.WithLabelValues(action, ResultDenied).Inc()
+		logging.FromContext(ctx).WithField("action", action).Warn("admin action denied")
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := newEntry(ctx, p, action, args)
+	entry.Result = ResultStarted
+	if err := insert(ctx, entry); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("action", action).Error("write audit log failed, action refused")
+		return nil, fmt.Errorf("failed to write audit log, action %s was not executed", action)
+	}
+	result, runErr := run(ctx)
+	update := map[string]interface{}{"result": ResultOK}
+	if runErr != nil {
+		update = map[string]interface{}{"result": ResultError, "error": runErr.Error()}
+	}
+	// 操作已经执行，即使请求已取消也要记录结果
+	updateCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), config.Current().MysqlTimeout)
+	defer cancel()
+	if err := db.GetDB().WithContext(updateCtx).Model(&model.AuditLog{}).Where("id = ?", entry.ID).Updates(update).Error; err != nil {
+		logging.FromContext(ctx).WithError(err).WithFields(log.Fields{"action": action, "audit_id": entry.ID}).
+			Error("update audit log result failed")
+	}
+	metrics.AdminActions.WithLabelValues(action, update["result"].(string)).Inc()
+	logger := logging.FromContext(ctx).WithFields(log.Fields{"action": action, "audit_id": entry.ID})
+	if runErr != nil {
+		logger.WithError(runErr).Warn("admin action failed")
+	} else {
+		logger.Info("admin action executed")
+	}
+	return result, runErr
+}
+
+// Recent 按时间倒序返回最近的审计日志，action 不为空时只返回该操作
+func Recent(ctx context.Context, action string, limit int) ([]model.AuditLog, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().MysqlTimeout)
+	defer cancel()
+	query := db.GetDB().WithContext(ctx).Order("id DESC").Limit(limit)
+	if action != "" {
+		query = query.Where("action = ?", action)
+	}
+	var logs []model.AuditLog
+	if err := query.Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+func newEntry(ctx context.Context, p Principal, action string, args map[string]interface{}) *model.AuditLog {
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		encoded = []byte(fmt.Sprintf("%q", fmt.Sprint(args)))
+	}
+	return &model.AuditLog{
+		Actor:      p.Subject,
+		Role:       p.Role.String(),
+		Action:     action,
+		Args:       string(encoded),
+		RemoteAddr: p.RemoteAddr,
+		RequestID:  logging.RequestID(ctx),
+	}
+}
+
+func insert(ctx context.Context, entry *model.AuditLog) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().MysqlTimeout)
+	defer cancel()
+	return db.GetDB().WithContext(ctx).Create(entry).Error
+}
@@ -0,0 +1,63 @@
+package admin
+
+import "strings"
+
+// Role 管理接口的角色，高级别的角色拥有低级别角色的全部权限
+type Role int
+
+const (
+	RoleNone     Role = iota // 没有任何权限
+	RoleViewer               // 只读：查看运行状态和刷盘状态
+	RoleOperator             // 运维：换发票据、开放、暂停和恢复投票、立即刷盘
+	RoleAdmin                // 管理员：结束投票、修改计划时间、认证结果、修改 maxVotes、查看审计日志
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+// Allows 当前角色是否拥有 required 角色的权限
+func (r Role) Allows(required Role) bool {
+	return r >= required
+}
+
+// ParseRole 解析角色名，不区分大小写
+func ParseRole(name string) (Role, bool) {
+	for r, n := range roleNames {
+		if strings.EqualFold(name, n) {
+			return r, true
+		}
+	}
+	return RoleNone, false
+}
+
+// roleFromClaim 从 claim 中取出角色，claim 可以是单个角色名，也可以是角色名数组，数组时取最高的角色
+func roleFromClaim(claim interface{}) Role {
+	var names []string
+	switch v := claim.(type) {
+	case string:
+		names = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	role := RoleNone
+	for _, name := range names {
+		if r, ok := ParseRole(name); ok && r > role {
+			role = r
+		}
+	}
+	return role
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"VoteMe/admin"
+	"VoteMe/auth"
+	"VoteMe/config"
+	"VoteMe/contest"
+	"VoteMe/db"
+	"VoteMe/graphql"
+	"VoteMe/ledger"
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"VoteMe/ratelimit"
+	"VoteMe/results"
+	"VoteMe/tally"
+	"VoteMe/tickets"
+	"VoteMe/tracing"
+	"VoteMe/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/handler"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minRestartDelay = 100 * time.Millisecond // 后台任务第一次重启前的等待时间
+	maxRestartDelay = 30 * time.Second       // 后台任务重启的最大等待时间
+)
+
+// App 管理整个服务的生命周期：配置、存储、后台任务以及 HTTP 服务
+type App struct {
+	conf    *config.GlobalConfig
+	servers []*http.Server
+	errs    chan error // HTTP 服务意外退出时的错误
+
+	cancel  context.CancelFunc // 取消所有后台任务
+	workers sync.WaitGroup     // 等待后台任务全部退出
+
+	unsubscribe     func()                          // 取消配置热更订阅
+	shutdownTracing func(ctx context.Context) error // 导出缓冲中的 span
+
+	startedAt    time.Time   // 启动时间，还没有刷盘过时用于计算刷盘延迟
+	shuttingDown atomic.Bool // 开始优雅关闭后 /readyz 返回 503
+}
+
+// New 加载配置并创建 App，此时不会连接任何外部依赖
+func New() *App {
+	rand.Seed(time.Now().UnixNano())
+	return &App{
+		conf: config.GetGlobalConf(),
+		errs: make(chan error, 3),
+	}
+}
+
+// Start 连接存储、启动后台任务和 HTTP 服务，返回后服务即可对外提供访问
+func (a *App) Start(ctx context.Context) error {
+	a.startedAt = time.Now()
+	config.Watch() // 热更运行时配置
+	debug.SetGCPercent(config.Current().GoGC)
+	a.unsubscribe = config.Subscribe(func(old, new *config.Settings) {
+		if old.GoGC != new.GoGC {
+			debug.SetGCPercent(new.GoGC)
+		}
+	})
+	shutdownTracing, err := tracing.Setup(ctx, a.conf.TraceConfig, a.conf.AppConfig.AppName)
+	if err != nil {
+		return fmt.Errorf("setup tracing failed: %w", err)
+	}
+	a.shutdownTracing = shutdownTracing
+	// 投票人认证
+	auth.Setup(a.conf.AuthConfig)
+	auth.ReloadOnSecretChange()
+	if a.conf.AdminConfig.Enabled {
+		admin.Setup(a.conf.AdminConfig)
+		admin.ReloadOnSecretChange()
+	}
+	// 认证结果的签名私钥
+	if err := results.Setup(a.conf.ResultsConfig); err != nil {
+		return fmt.Errorf("load results signing key failed: %w", err)
+	}
+	results.ReloadOnSecretChange()
+	ledger.Setup(a.conf.LedgerConfig.Enabled)
+	// 密钥文件更新后重新建立连接，启动重试期间更新的密码也能用上
+	db.ReloadOnSecretChange()
+	if err := config.WatchSecrets(); err != nil {
+		return fmt.Errorf("watch secret files failed: %w", err)
+	}
+	// 初始化数据库和 Redis，连不上时重试到 startup_timeout
+	connectCtx, cancelConnect := context.WithTimeout(ctx, a.conf.AppConfig.StartupTimeout)
+	err = db.Connect(connectCtx)
+	cancelConnect()
+	if err != nil {
+		return fmt.Errorf("connect storage failed: %w", err)
+	}
+	registerPoolMetrics()
+	if err := tickets.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrate tickets table failed: %w", err)
+	}
+	if a.conf.LedgerConfig.Enabled {
+		if err := ledger.Migrate(ctx); err != nil {
+			return fmt.Errorf("migrate ledger tables failed: %w", err)
+		}
+	}
+	if a.conf.VotingConfig.Mode == config.VotingRanked {
+		if err := tally.Migrate(ctx); err != nil {
+			return fmt.Errorf("migrate ballots table failed: %w", err)
+		}
+	}
+	if a.conf.AdminConfig.Enabled {
+		if err := admin.Migrate(ctx); err != nil {
+			return fmt.Errorf("migrate audit log table failed: %w", err)
+		}
+		if err := results.Migrate(ctx); err != nil {
+			return fmt.Errorf("migrate certified results tables failed: %w", err)
+		}
+	}
+
+	// 初始化投票活动状态，已有状态时保留
+	if err := contest.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrate contest table failed: %w", err)
+	}
+	if err := contest.Init(ctx, a.conf.ContestConfig); err != nil {
+		return fmt.Errorf("init contest state failed: %w", err)
+	}
+
+	// 数据库中的信息预存到 redis 中
+	if err := utils.LoadCandidates(ctx); err != nil {
+		return fmt.Errorf("load candidates to redis failed: %w", err)
+	}
+
+	workerCtx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	// 生成票据
+	a.supervise(workerCtx, "ticketGenerator", utils.TicketGenerator)
+	// 将redis中的数据累加到mysql中
+	a.supervise(workerCtx, "votesFlusher", utils.VotesFlusher)
+	// 按计划时间开放和结束投票
+	a.supervise(workerCtx, "contestScheduler", contest.Scheduler)
+	// 按保留时间清理票据记录
+	if a.conf.TicketsConfig.Retention > 0 {
+		a.supervise(workerCtx, "ticketPruner", tickets.Pruner(a.conf.TicketsConfig.Retention, a.conf.TicketsConfig.PruneInterval))
+	}
+	// 定期生成账本检查点
+	if a.conf.LedgerConfig.Enabled {
+		a.supervise(workerCtx, "ledgerCheckpointer", ledger.Checkpointer(a.conf.LedgerConfig.CheckpointInterval))
+	}
+
+	schema, err := graphql.NewGraphQLSchema()
+	if err != nil {
+		a.cancel()
+		return fmt.Errorf("failed to create new schema: %w", err)
+	}
+	// handler会解析请求，执行对应的GraphQL操作，并返回结果
+	h := handler.New(&handler.Config{
+		Schema: &schema, // 设置handler使用的GraphQL schema
+		Pretty: true,    // 设置返回的JSON数据格式化，便于阅读
+	})
+	mux := http.NewServeMux()
+	// 限流在认证之前，超限的请求不再校验 JWT
+	mux.Handle("/graphql", logging.Middleware(tracing.Middleware("graphql", ratelimit.Middleware(auth.Middleware(h)))))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+	a.serve(&http.Server{Addr: a.conf.AppConfig.Addr(), Handler: mux})
+
+	// 管理接口，单独监听一个地址
+	if a.conf.AdminConfig.Enabled {
+		adminSchema, err := graphql.NewAdminSchema()
+		if err != nil {
+			a.cancel()
+			return fmt.Errorf("failed to create admin schema: %w", err)
+		}
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/graphql", logging.Middleware(tracing.Middleware("admin",
+			admin.Middleware(handler.New(&handler.Config{Schema: &adminSchema, Pretty: true})))))
+		a.serve(&http.Server{Addr: a.conf.AdminConfig.Addr, Handler: adminMux})
+	}
+
+	// pprof
+	if a.conf.AppConfig.Pprof {
+		runtime.SetBlockProfileRate(1)     // 开启对阻塞操作的跟踪，block
+		runtime.SetMutexProfileFraction(1) // 开启对锁调用的跟踪，mutex
+		a.serve(&http.Server{Addr: a.conf.AppConfig.PprofAddr, Handler: pprofHandler()})
+	}
+	return nil
+}
+
+// Err 返回 HTTP 服务意外退出时的错误，调用方收到后应调用 Stop
+func (a *App) Err() <-chan error {
+	return a.errs
+}
+
+// Stop 按顺序关闭服务：/readyz 返回 503 并等待 shutdown_drain，停止接收请求并等待处理中的请求结束，停止后台任务，
+// 同步执行最后一次刷盘，最后释放连接。不会清理 redis 和 tickets 表，其他实例可能还依赖这些数据
+func (a *App) Stop(ctx context.Context) error {
+	a.shuttingDown.Store(true) // 负载均衡先摘除实例，不再分配新请求
+	var errs []error
+	// 负载均衡发现 /readyz 返回 503 之前仍会分配新请求，等一段时间再停止接收请求
+	if drain := a.conf.AppConfig.ShutdownDrain; drain > 0 {
+		log.Infof("draining for %s before shutting down servers", drain)
+		select {
+		case <-time.After(drain):
+		case <-ctx.Done():
+		}
+	}
+	if a.unsubscribe != nil {
+		a.unsubscribe()
+	}
+	for _, srv := range a.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown server %s: %w", srv.Addr, err))
+		}
+	}
+
+	if a.cancel != nil {
+		a.cancel()
+		done := make(chan struct{})
+		go func() {
+			a.workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			// 保证redis中新增投票能够刷盘
+			log.Info("VotesCacheToDb......")
+			if err := utils.SyncVotes(logging.WithFields(ctx, log.Fields{"worker": "finalSync"})); err != nil {
+				errs = append(errs, fmt.Errorf("final sync votes: %w", err))
+			}
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("wait background workers: %w", ctx.Err()))
+		}
+	}
+
+	if err := db.CloseRedis(); err != nil {
+		errs = append(errs, fmt.Errorf("close redis: %w", err))
+	}
+	if err := db.CloseDB(); err != nil {
+		errs = append(errs, fmt.Errorf("close mysql: %w", err))
+	}
+	// 最后关闭，最后一次刷盘的 span 也能导出
+	if a.shutdownTracing != nil {
+		if err := a.shutdownTracing(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown tracing: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serve 在后台启动 HTTP 服务，非正常退出时通过 Err 通知调用方
+func (a *App) serve(srv *http.Server) {
+	a.servers = append(a.servers, srv)
+	go func() {
+		log.Infof("Now server is running on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.errs <- fmt.Errorf("server %s: %w", srv.Addr, err)
+		}
+	}()
+}
+
+// supervise 运行后台任务，任务返回错误或 panic 时按指数退避重启，ctx 取消后退出
+func (a *App) supervise(ctx context.Context, name string, run func(context.Context) error) {
+	ctx = logging.WithFields(ctx, log.Fields{"worker": name})
+	logger := logging.FromContext(ctx)
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		delay := minRestartDelay
+		for {
+			err := runSafely(ctx, run)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = errors.New("exited unexpectedly")
+			}
+			logger.WithError(err).Errorf("worker failed, restart in %s", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if delay *= 2; delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+		}
+	}()
+}
+
+// runSafely 执行后台任务，把 panic 转换为错误
+func runSafely(ctx context.Context, run func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return run(ctx)
+}
+
+// registerPoolMetrics 注册 mysql 和 redis 连接池指标，重复注册时忽略
+func registerPoolMetrics() {
+	err := metrics.RegisterDBStats(func() (*sql.DB, error) { return db.GetDB().DB() })
+	if err != nil {
+		log.Warnf("register mysql pool metrics failed: %v", err)
+	}
+	if err := metrics.RegisterRedisPoolStats(db.GetRedisCLi); err != nil {
+		log.Warnf("register redis pool metrics failed: %v", err)
+	}
+}
+
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/metrics"
+	"VoteMe/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// checkResult 单项检查的结果
+type checkResult struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`  // 不通过的原因
+	Detail string `json:"detail,omitempty"` // 附加信息，例如刷盘延迟
+}
+
+// healthReport /healthz 和 /readyz 的响应体
+type healthReport struct {
+	Status string                 `json:"status"` // ok、ready、not_ready、shutting_down
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+func newCheck(err error) checkResult {
+	if err != nil {
+		return checkResult{Error: err.Error()}
+	}
+	return checkResult{OK: true}
+}
+
+// healthz 存活检查，进程能处理请求就返回 200，不检查外部依赖，避免依赖故障时实例被反复重启
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// readyz 就绪检查，依赖可达、候选人已加载、有当前票据并且刷盘没有积压时返回 200，
+// 否则返回 503，负载均衡摘除该实例。优雅关闭开始后立即返回 503
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	if a.shuttingDown.Load() {
+		writeReport(w, http.StatusServiceUnavailable, healthReport{Status: "shutting_down"})
+		return
+	}
+
+	settings := config.Current()
+	checks := map[string]checkResult{
+		"redis":      newCheck(pingRedis(r.Context(), settings.RedisTimeout)),
+		"mysql":      newCheck(pingMysql(r.Context(), settings.MysqlTimeout)),
+		"candidates": newCheck(checkCandidates()),
+		"ticket":     newCheck(checkTicket()),
+		"flusher":    checkFlusher(metrics.SyncLag(), time.Since(a.startedAt), settings.MaxSyncLag),
+	}
+	report := healthReport{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			report.Status = "not_ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeReport(w, code, report)
+}
+
+func pingRedis(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return db.GetRedisCLi().Ping(ctx).Err()
+}
+
+func pingMysql(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sqlDB, err := db.GetDB().DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func checkCandidates() error {
+	if !utils.CandidatesLoaded() {
+		return fmt.Errorf("candidates not loaded to redis")
+	}
+	return nil
+}
+
+func checkTicket() error {
+	if utils.GetCurrentTicket() == "" {
+		return fmt.Errorf("no current ticket")
+	}
+	return nil
+}
+
+// checkFlusher 检查刷盘是否积压，lag 为 0 表示启动后还没有成功刷盘过，此时按启动时长计算
+func checkFlusher(lag, uptime, maxLag time.Duration) checkResult {
+	if lag == 0 {
+		lag = uptime
+	}
+	result := checkResult{OK: lag <= maxLag, Detail: fmt.Sprintf("last successful sync %s ago", lag.Round(time.Millisecond))}
+	if !result.OK {
+		result.Error = fmt.Sprintf("votes not flushed for more than %s", maxLag)
+	}
+	return result
+}
+
+func writeReport(w http.ResponseWriter, code int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.WithError(err).Warn("write health report failed")
+	}
+}
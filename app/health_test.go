@@ -0,0 +1,32 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckFlusher(t *testing.T) {
+	// 还没有刷盘过，按启动时长计算
+	assert.True(t, checkFlusher(0, 10*time.Second, time.Minute).OK)
+	assert.False(t, checkFlusher(0, 2*time.Minute, time.Minute).OK)
+	assert.True(t, checkFlusher(5*time.Second, time.Hour, time.Minute).OK)
+	assert.False(t, checkFlusher(2*time.Minute, time.Hour, time.Minute).OK)
+}
+
+// 测试优雅关闭开始后 /readyz 立即返回 503，/healthz 仍然返回 200
+func TestReadyzDuringShutdown(t *testing.T) {
+	a := &App{}
+	a.shuttingDown.Store(true)
+
+	rec := httptest.NewRecorder()
+	a.readyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"shutting_down"`)
+
+	rec = httptest.NewRecorder()
+	a.healthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
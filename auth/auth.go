@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"VoteMe/config"
+	"VoteMe/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// ErrUnauthenticated 开启认证后请求没有携带 JWT
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrWrongVoter 票据不是签发给当前投票人的
+	ErrWrongVoter = errors.New("ticket was issued to a different voter")
+)
+
+type ctxKey int
+
+const voterKey ctxKey = iota
+
+var (
+	current      atomic.Pointer[Verifier] // Setup 之后才会创建
+	ticketSecret atomic.Pointer[config.Secret]
+)
+
+// Setup 按配置开启认证，没有开启时所有请求都是匿名的，获取票据和投票不需要认证
+func Setup(conf config.AuthConf) {
+	if !conf.Enabled {
+		current.Store(nil)
+		return
+	}
+	ticketSecret.Store(&conf.TicketSecret)
+	current.Store(NewVerifier(conf.JWTConf))
+	log.WithFields(log.Fields{"issuer": conf.Issuer, "jwks": conf.JWKSURL}).Info("voter authentication enabled")
+}
+
+// ReloadOnSecretChange 密钥文件更新后使用新的密钥，旧密钥签发的 JWT 和票据随之失效
+func ReloadOnSecretChange() {
+	config.OnSecretChange(func(field string, value config.Secret) {
+		switch field {
+		case "auth.hmac_secret":
+			if v := current.Load(); v != nil {
+				v.SetHMACSecret(value)
+			}
+		case "auth.ticket_secret":
+			ticketSecret.Store(&value)
+		}
+	})
+}
+
+// Enabled 是否开启了投票人认证
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// VoterFromContext 返回当前请求的投票人标识
+func VoterFromContext(ctx context.Context) (string, bool) {
+	voter, ok := ctx.Value(voterKey).(string)
+	return voter, ok
+}
+
+// WithVoter 在 ctx 中记录投票人标识
+func WithVoter(ctx context.Context, voter string) context.Context {
+	ctx = context.WithValue(ctx, voterKey, voter)
+	return logging.WithFields(ctx, log.Fields{"voter": voter})
+}
+
+// RequireVoter 开启认证时返回当前投票人，没有携带 JWT 时返回 ErrUnauthenticated
+func RequireVoter(ctx context.Context) (string, error) {
+	voter, ok := VoterFromContext(ctx)
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	return voter, nil
+}
+
+// Middleware 校验 Authorization: Bearer 中的 JWT，通过后把投票人写入 ctx
+// 没有携带 JWT 的请求按匿名处理，由解析函数决定是否需要认证；携带了但校验失败时直接返回 401
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := current.Load()
+		header := r.Header.Get("Authorization")
+		if v == nil || header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		raw, ok := BearerToken(header)
+		if !ok {
+			WriteUnauthorized(w, "authorization header must be a bearer token")
+			return
+		}
+		voter, _, err := v.Verify(r.Context(), raw)
+		if err != nil {
+			logging.FromContext(r.Context()).WithError(err).Info("reject invalid token")
+			WriteUnauthorized(w, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithVoter(r.Context(), voter)))
+	})
+}
+
+// BearerToken 从 Authorization 头中取出 Bearer token
+func BearerToken(header string) (string, bool) {
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(raw), true
+}
+
+// WriteUnauthorized 返回 401，响应体与 GraphQL 错误格式一致
+func WriteUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": nil,
+		"errors": []map[string]interface{}{{
+			"message":    message,
+			"extensions": map[string]interface{}{"code": "UNAUTHENTICATED"},
+		}},
+	})
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"VoteMe/config"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// serve 使用 Middleware 处理请求，返回状态码和解析出的投票人
+func serve(token string) (int, string) {
+	var voter string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		voter, _ = VoterFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code, voter
+}
+
+func TestHMACToken(t *testing.T) {
+	Setup(config.AuthConf{Enabled: true, JWTConf: config.JWTConf{Issuer: "voteme", HMACSecret: testSecret, SubjectClaim: "sub"}, TicketSecret: testSecret})
+	defer Setup(config.AuthConf{})
+
+	sign := func(claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+		assert.NoError(t, err)
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	code, voter := serve(sign(jwt.MapClaims{"sub": "alice", "iss": "voteme", "exp": exp}))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alice", voter)
+
+	code, _ = serve(sign(jwt.MapClaims{"sub": "alice", "iss": "other", "exp": exp}))
+	assert.Equal(t, http.StatusUnauthorized, code, "签发方不对")
+	code, _ = serve(sign(jwt.MapClaims{"sub": "alice", "iss": "voteme", "exp": time.Now().Add(-time.Hour).Unix()}))
+	assert.Equal(t, http.StatusUnauthorized, code, "已过期")
+
+	code, voter = serve("")
+	assert.Equal(t, http.StatusOK, code, "没有携带 JWT 时按匿名处理")
+	assert.Empty(t, voter)
+}
+
+func TestJWKSToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer idp.Close()
+
+	Setup(config.AuthConf{Enabled: true, JWTConf: config.JWTConf{JWKSURL: idp.URL, JWKSRefresh: time.Minute, SubjectClaim: "sub"}, TicketSecret: testSecret})
+	defer Setup(config.AuthConf{})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	code, voter := serve(signed)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "bob", voter)
+
+	// 没有配置 hmac_secret 时不接受 HS256，避免用公钥当作 HMAC 密钥伪造签名
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()}).
+		SignedString([]byte(testSecret))
+	code, _ = serve(hs)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestBindTicket(t *testing.T) {
+	Setup(config.AuthConf{Enabled: true, JWTConf: config.JWTConf{HMACSecret: testSecret, SubjectClaim: "sub"}, TicketSecret: testSecret})
+	defer Setup(config.AuthConf{})
+
+	bound := BindTicket("abcdef1234", "alice")
+	ticket, err := OpenTicket(bound, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef1234", ticket)
+
+	_, err = OpenTicket(bound, "bob")
+	assert.ErrorIs(t, err, ErrWrongVoter)
+	_, err = OpenTicket("abcdef1234", "alice")
+	assert.ErrorIs(t, err, ErrWrongVoter)
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// 绑定后的票据格式为 <票据>.<签名>，签名由票据和投票人计算，其他投票人拿到也无法使用
+const ticketSep = "."
+
+// BindTicket 把票据绑定到投票人
+func BindTicket(ticket, voter string) string {
+	return ticket + ticketSep + ticketMAC(ticket, voter)
+}
+
+// OpenTicket 校验票据是否签发给 voter，通过后返回原始票据
+func OpenTicket(bound, voter string) (string, error) {
+	ticket, mac, ok := strings.Cut(bound, ticketSep)
+	if !ok || !hmac.Equal([]byte(mac), []byte(ticketMAC(ticket, voter))) {
+		return "", ErrWrongVoter
+	}
+	return ticket, nil
+}
+
+func ticketMAC(ticket, voter string) string {
+	var key []byte
+	if secret := ticketSecret.Load(); secret != nil {
+		key = []byte(secret.Reveal())
+	}
+	h := hmac.New(sha256.New, key)
+	// 用 0 分隔，避免票据和投票人拼接后产生歧义
+	h.Write([]byte(ticket))
+	h.Write([]byte{0})
+	h.Write([]byte(voter))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
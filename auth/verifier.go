@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"VoteMe/config"
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"sync/atomic"
+	"time"
+)
+
+// leeway 校验 exp、nbf 时允许的时钟误差
+const leeway = 30 * time.Second
+
+// Verifier 校验 JWT 的签名、有效期以及 iss、aud，投票人认证和管理接口各用一个
+type Verifier struct {
+	conf       config.JWTConf
+	jwks       *jwks
+	methods    []string
+	hmacSecret atomic.Pointer[config.Secret] // 密钥文件更新后替换
+}
+
+// NewVerifier 按配置创建 Verifier，配置了 hmac_secret 时接受 HS256，配置了 jwks_url 时接受非对称签名
+func NewVerifier(conf config.JWTConf) *Verifier {
+	v := &Verifier{conf: conf}
+	if conf.HMACSecret != "" {
+		v.methods = append(v.methods, jwt.SigningMethodHS256.Alg())
+	}
+	if conf.JWKSURL != "" {
+		v.jwks = newJWKS(conf.JWKSURL, conf.JWKSRefresh)
+		v.methods = append(v.methods, "RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512")
+	}
+	v.hmacSecret.Store(&conf.HMACSecret)
+	return v
+}
+
+// SetHMACSecret 替换 HS256 密钥，旧密钥签发的 JWT 随之失效
+func (v *Verifier) SetHMACSecret(secret config.Secret) {
+	v.hmacSecret.Store(&secret)
+}
+
+// Verify 校验 JWT，返回 subject_claim 对应的用户标识和全部 claims
+func (v *Verifier) Verify(ctx context.Context, raw string) (string, jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if v.conf.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.conf.Issuer))
+	}
+	if v.conf.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.conf.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			secret := v.hmacSecret.Load()
+			if secret == nil || *secret == "" {
+				return nil, fmt.Errorf("hmac signed tokens are not accepted")
+			}
+			return []byte(secret.Reveal()), nil
+		}
+		if v.jwks == nil {
+			return nil, fmt.Errorf("no jwks configured for %s tokens", t.Method.Alg())
+		}
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	subject, _ := claims[v.conf.SubjectClaim].(string)
+	if subject == "" {
+		return "", nil, fmt.Errorf("claim %s is missing", v.conf.SubjectClaim)
+	}
+	return subject, claims, nil
+}
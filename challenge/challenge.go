@@ -0,0 +1,229 @@
+package challenge
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/metrics"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidChallenge 挑战不存在、已过期或者已经使用过
+	ErrInvalidChallenge = errors.New("challenge is unknown, expired or already used")
+	// ErrInsufficientWork nonce 没有解出挑战
+	ErrInsufficientWork = errors.New("nonce does not solve the challenge")
+	// ErrDisabled 没有开启 challenge，不发放挑战
+	ErrDisabled = errors.New("challenge is disabled")
+	// ErrUnboundTicket 开启 challenge 后票据没有绑定解出的挑战
+	ErrUnboundTicket = errors.New("ticket was not issued for a solved challenge")
+	// ErrTicketExhausted 解出挑战换到的票据已经用完了使用次数
+	ErrTicketExhausted = errors.New("challenge ticket has no uses left")
+)
+
+// Rejected 是否是客户端的问题导致的错误，其他错误是 redis 出错
+func Rejected(err error) bool {
+	for _, target := range []error{ErrInvalidChallenge, ErrInsufficientWork, ErrDisabled, ErrUnboundTicket, ErrTicketExhausted} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// idLen 挑战 id 的长度，16 字节随机数的十六进制
+const idLen = 32
+
+// 绑定挑战后的票据格式为 <票据>~<挑战>，每个挑战的答案只能投 challenge.ticketUses 次票
+const ticketSep = "~"
+
+// Challenge 服务端下发的工作量证明挑战
+// 客户端需要找到 nonce，使 sha256(ID + ":" + nonce) 的前 Difficulty 位都是 0
+type Challenge struct {
+	ID         string
+	Difficulty int
+	ExpiresAt  time.Time
+}
+
+// Issue 生成一个新的挑战，挑战保存在 redis 中，所有实例都能校验；没有开启 challenge 时返回 ErrDisabled
+func Issue(ctx context.Context) (*Challenge, error) {
+	s := config.Current()
+	if !s.ChallengeEnabled {
+		return nil, ErrDisabled
+	}
+	buf := make([]byte, idLen/2)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	c := &Challenge{
+		ID:         hex.EncodeToString(buf),
+		Difficulty: s.ChallengeDifficulty,
+		ExpiresAt:  time.Now().Add(s.ChallengeTTL),
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RedisTimeout)
+	defer cancel()
+	// 保存签发时的难度，热更难度不影响已经发出的挑战
+	if err := db.GetRedisCLi().Set(ctx, keys.Challenge(c.ID), c.Difficulty, s.ChallengeTTL).Err(); err != nil {
+		return nil, err
+	}
+	metrics.Challenges.WithLabelValues("issued").Inc()
+	return c, nil
+}
+
+// Verify 校验 nonce 是否解出了挑战，通过后挑战立即失效，同一个挑战只能换一次票据
+// 换到的票据需要用 BindTicket 绑定挑战，在挑战有效期内可以投 challenge.ticketUses 次票
+// 答案不对时挑战保留，客户端可以在有效期内继续提交
+func Verify(ctx context.Context, id, nonce string) error {
+	if !validID(id) {
+		metrics.Challenges.WithLabelValues("invalid").Inc()
+		return ErrInvalidChallenge
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	key := keys.Challenge(id)
+	difficulty, err := db.GetRedisCLi().Get(ctx, key).Int()
+	if err == redis.Nil {
+		metrics.Challenges.WithLabelValues("invalid").Inc()
+		return ErrInvalidChallenge
+	} else if err != nil {
+		return err
+	}
+	if !Solved(id, nonce, difficulty) {
+		metrics.Challenges.WithLabelValues("insufficient").Inc()
+		return ErrInsufficientWork
+	}
+	// 并发提交同一个答案时只有一个请求能删除成功，删除的同时写入换到的票据的使用次数
+	s := config.Current()
+	deleted, err := solveScript.Run(ctx, db.GetRedisCLi(), []string{key, keys.ChallengeTicket(id)},
+		s.ChallengeTicketUses, s.ChallengeTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		metrics.Challenges.WithLabelValues("invalid").Inc()
+		return ErrInvalidChallenge
+	}
+	metrics.Challenges.WithLabelValues("solved").Inc()
+	return nil
+}
+
+// solveScript 删除挑战，删除成功时写入换到的票据的使用次数
+// KEYS[1] 为挑战，KEYS[2] 为使用次数；ARGV[1] 为使用次数，ARGV[2] 为有效期（毫秒）
+var solveScript = redis.NewScript(`
+if redis.call('DEL', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// useScript 使用次数大于 0 时减一；返回 -2 表示不存在或已过期，-1 表示已经用完
+var useScript = redis.NewScript(`
+local uses = redis.call('GET', KEYS[1])
+if not uses then
+	return -2
+end
+if tonumber(uses) <= 0 then
+	return -1
+end
+return redis.call('DECR', KEYS[1])
+`)
+
+// BindTicket 把票据绑定到解出的挑战，Verify 通过后才能调用
+func BindTicket(ticket, id string) string {
+	return ticket + ticketSep + id
+}
+
+// OpenTicket 拆出原始票据和绑定的挑战，没有绑定挑战时 id 为空
+func OpenTicket(bound string) (ticket, id string) {
+	ticket, id, _ = strings.Cut(bound, ticketSep)
+	return ticket, id
+}
+
+// UseTicket 消耗一次挑战 id 换到的票据的使用次数
+func UseTicket(ctx context.Context, id string) error {
+	if !validID(id) {
+		metrics.Challenges.WithLabelValues("invalid").Inc()
+		return ErrInvalidChallenge
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	n, err := useScript.Run(ctx, db.GetRedisCLi(), []string{keys.ChallengeTicket(id)}).Int()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case -2:
+		metrics.Challenges.WithLabelValues("invalid").Inc()
+		return ErrInvalidChallenge
+	case -1:
+		metrics.Challenges.WithLabelValues("exhausted").Inc()
+		return ErrTicketExhausted
+	}
+	metrics.Challenges.WithLabelValues("used").Inc()
+	return nil
+}
+
+// restoreScript 使用次数还没过期时加一
+var restoreScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('INCR', KEYS[1])
+`)
+
+// RestoreTicket 归还 UseTicket 消耗的一次使用次数，票据本身校验失败时调用，客户端不需要重新解挑战
+func RestoreTicket(ctx context.Context, id string) error {
+	if !validID(id) {
+		return ErrInvalidChallenge
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	return restoreScript.Run(ctx, db.GetRedisCLi(), []string{keys.ChallengeTicket(id)}).Err()
+}
+
+// validID 挑战 id 必须是 Issue 生成的格式，即 idLen 位小写十六进制，不能拼出其他键
+func validID(id string) bool {
+	if len(id) != idLen {
+		return false
+	}
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Solved 判断 nonce 是否解出了挑战
+func Solved(id, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(id + ":" + nonce))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
+
+// Solve 暴力计算挑战的答案，供测试和 Go 客户端使用
+func Solve(id string, difficulty int) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if Solved(id, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package challenge
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	nonce := Solve("abc", 12)
+	assert.True(t, Solved("abc", nonce, 12))
+	assert.False(t, Solved("abd", nonce, 12), "答案只对签发的挑战有效")
+	assert.False(t, Solved("abc", nonce, 256+1))
+}
+
+func TestBindTicket(t *testing.T) {
+	ticket, id := OpenTicket(BindTicket("abc123", "c1"))
+	assert.Equal(t, "abc123", ticket)
+	assert.Equal(t, "c1", id)
+
+	ticket, id = OpenTicket("abc123")
+	assert.Equal(t, "abc123", ticket)
+	assert.Empty(t, id, "没有绑定挑战")
+}
+
+// 测试只接受 Issue 生成的 id，不能用 "<id>:uses" 把使用次数当作难度绕过工作量证明
+func TestRejectForgedID(t *testing.T) {
+	id := "0123456789abcdef0123456789abcdef"
+	assert.True(t, validID(id))
+	for _, forged := range []string{id + ":uses", id[:31], "0123456789ABCDEF0123456789ABCDEF", "", "*"} {
+		assert.False(t, validID(forged), forged)
+		assert.ErrorIs(t, Verify(context.Background(), forged, "0"), ErrInvalidChallenge, forged)
+		assert.ErrorIs(t, UseTicket(context.Background(), forged), ErrInvalidChallenge, forged)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/ledger"
+	"VoteMe/results"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"os"
+)
+
+// runConfigCommand 执行 voteme config 子命令，返回进程退出码
+//
+//	voteme config check [--config path] [其他配置参数]
+//
+// 校验配置文件、环境变量和命令行参数合并后的最终配置，不连接任何外部依赖，适合在 CI 中使用
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: voteme config check [--config path] [flags]")
+		return 2
+	}
+	if err := config.ParseFlags(args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := config.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("config ok, %s\n", config.Current())
+	return 0
+}
+
+// runVerifyResultsCommand 执行 voteme verify-results 子命令，返回进程退出码
+//
+//	voteme verify-results [--public-key path] results.json|results.csv
+//
+// 离线校验 certifyResults 导出的结果，不需要配置文件，也不连接任何外部依赖
+// 应使用主办方事先公布的公钥校验，不指定时只能证明结果与导出中自带的公钥一致
+func runVerifyResultsCommand(args []string) int {
+	fs := pflag.NewFlagSet("verify-results", pflag.ContinueOnError)
+	publicKeyPath := fs.String("public-key", "", "Ed25519 公钥文件，PEM 或 base64 格式")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: voteme verify-results [--public-key path] results.json|results.csv")
+		return 2
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	e, err := results.Decode(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var pinned ed25519.PublicKey
+	if *publicKeyPath != "" {
+		keyData, err := os.ReadFile(*publicKeyPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if pinned, err = results.ParsePublicKey(keyData); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: no --public-key given, only checking the export against its embedded key")
+	}
+	if err := results.Verify(e, pinned); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, results.ErrBadSignature) {
+			fmt.Fprintln(os.Stderr, "results have been modified or were not signed by this key")
+		}
+		return 1
+	}
+	p := e.Payload
+	fmt.Printf("signature ok\ncontest: %s\nclosed at: %s\ncertified at: %s by %s\nsha256: %s\n",
+		p.Contest, p.ClosedAt, p.CertifiedAt, p.CertifiedBy, e.Hash)
+	for _, t := range p.Results {
+		fmt.Printf("%s\t%d\n", t.Candidate, t.Votes)
+	}
+	fmt.Printf("total\t%d\n", p.Total)
+	return 0
+}
+
+// runVerifyLedgerCommand 执行 voteme verify-ledger 子命令，返回进程退出码
+//
+//	voteme verify-ledger [--config path] [其他配置参数]
+//
+// 连接配置中的 mysql，遍历刷盘账本并报告第一处断开的位置，可以在服务运行时执行
+func runVerifyLedgerCommand(args []string) int {
+	if err := config.ParseFlags(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := config.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetGlobalConf().AppConfig.StartupTimeout)
+	err := db.ConnectDB(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer db.CloseDB()
+	report, err := ledger.Verify(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if report.Broken != nil {
+		fmt.Printf("ledger broken after %d valid entries and %d checkpoints\n%s\n",
+			report.Entries, report.Checkpoints, report.Broken)
+		return 1
+	}
+	fmt.Printf("ledger ok, %d entries, %d checkpoints\n", report.Entries, report.Checkpoints)
+	return 0
+}
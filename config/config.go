@@ -5,35 +5,160 @@ import (
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 )
 
 var (
-	config                 GlobalConfig  // 全局配置文件
-	once                   sync.Once     // 只执行一次的代码
-	MaxVotes               int           // 票据最大使用次数
-	TicketsUpdateTime      time.Duration // 票据更新时间
-	updateDebounceTimer    *time.Timer   // 配置更新防抖动
-	TicketCacheRefreshTime time.Duration // 票数缓存刷新时间
-	VotesCacheToDbTime     time.Duration // redis中缓存数据的刷盘时间
-	TicketLen              int           // 票据长度
-	MinIdleCoons           int           // 最小空闲连接
-	GoGC                   int           // GoGc 步调
+	config              GlobalConfig // 全局配置文件
+	once                sync.Once    // 只执行一次的代码
+	loadErr             error        // 配置加载的结果
+	updateDebounceTimer *time.Timer  // 配置更新防抖动
 )
 
 const debounceDuration = 1 * time.Second
 
 type GlobalConfig struct {
-	DbConfig    DbConf    `yaml:"db" mapstructure:"db"`       // 数据库配置
-	RedisConfig RedisConf `yaml:"redis" mapstructure:"redis"` // redis 配置
+	AppConfig     AppConf     `yaml:"app" mapstructure:"app"`         // 应用配置
+	DbConfig      DbConf      `yaml:"db" mapstructure:"db"`           // 数据库配置
+	RedisConfig   RedisConf   `yaml:"redis" mapstructure:"redis"`     // redis 配置
+	LogConfig     LogConf     `yaml:"log" mapstructure:"log"`         // 日志配置
+	TraceConfig   TraceConf   `yaml:"trace" mapstructure:"trace"`     // 链路追踪配置
+	AuthConfig    AuthConf    `yaml:"auth" mapstructure:"auth"`       // 投票人认证配置
+	AdminConfig   AdminConf   `yaml:"admin" mapstructure:"admin"`     // 管理接口配置
+	ContestConfig ContestConf `yaml:"contest" mapstructure:"contest"` // 投票活动的计划时间
+	ResultsConfig ResultsConf `yaml:"results" mapstructure:"results"` // 结果认证配置
+	LedgerConfig  LedgerConf  `yaml:"ledger" mapstructure:"ledger"`   // 刷盘账本配置
+	VotingConfig  VotingConf  `yaml:"voting" mapstructure:"voting"`   // 投票方式
+	TicketsConfig TicketsConf `yaml:"tickets" mapstructure:"tickets"` // 票据历史配置
+}
+
+// 投票方式
+const (
+	VotingSingle   = "single"   // 单选，每张选票只能选一个选手
+	VotingApproval = "approval" // 认可投票，每个被选中的选手加一票
+	VotingWeighted = "weighted" // 加权投票，按选择的顺序给分，例如第一名 3 分、第二名 2 分
+	VotingRanked   = "ranked"   // 排序复选，按即时决选（IRV）计算结果
+)
+
+// VotingConf 投票方式和选票规则，活动进行中不应修改
+type VotingConf struct {
+	Mode            string         `yaml:"mode" mapstructure:"mode"`                         // single、approval、weighted 或 ranked
+	MinSelections   int            `yaml:"min_selections" mapstructure:"min_selections"`     // 最少选几个，0 表示至少选一个
+	MaxSelections   int            `yaml:"max_selections" mapstructure:"max_selections"`     // 最多选几个，0 表示不限；single 固定为 1，weighted 不超过分数的个数
+	Points          []int          `yaml:"points" mapstructure:"points"`                     // weighted 按选择顺序给的分数
+	AllowDuplicates bool           `yaml:"allow_duplicates" mapstructure:"allow_duplicates"` // approval 是否允许同一个选手选多次，每次加一票
+	Categories      []CategoryConf `yaml:"categories" mapstructure:"categories"`             // 必选分类
+}
+
+// CategoryConf 必选分类，每张选票至少要选 Min 个分类中的选手
+type CategoryConf struct {
+	Name       string   `yaml:"name" mapstructure:"name"`
+	Candidates []string `yaml:"candidates" mapstructure:"candidates"`
+	Min        int      `yaml:"min" mapstructure:"min"`
+}
+
+// TicketsConf 票据历史配置，每张签发过的票据在 tickets 表中保留一条记录
+type TicketsConf struct {
+	Retention     time.Duration `yaml:"retention" mapstructure:"retention"`           // 票据记录保留多久，0 表示永久保留
+	PruneInterval time.Duration `yaml:"prune_interval" mapstructure:"prune_interval"` // 每隔多久清理一次超过保留时间的记录
+}
+
+// LedgerConf 刷盘账本配置，开启后每次刷盘都会写入一条哈希链记录，并定期生成 Merkle 根检查点
+type LedgerConf struct {
+	Enabled            bool          `yaml:"enabled" mapstructure:"enabled"`
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval" mapstructure:"checkpoint_interval"` // 生成检查点的间隔
+}
+
+// ResultsConf 结果认证配置
+type ResultsConf struct {
+	SigningKey Secret `yaml:"signing_key" mapstructure:"signing_key"` // 签名认证结果的 Ed25519 私钥，PKCS#8 PEM 格式，支持 env: 和 file: 引用
+}
+
+// ContestConf 投票活动的计划开始和结束时间，RFC3339 格式，为空表示不按时间自动开始或结束
+// 只在 redis 中还没有活动状态时用于初始化，之后通过管理接口调整
+type ContestConf struct {
+	StartsAt string `yaml:"starts_at" mapstructure:"starts_at"`
+	EndsAt   string `yaml:"ends_at" mapstructure:"ends_at"`
+}
+
+// Schedule 解析计划开始和结束时间，为空时返回零值
+func (c ContestConf) Schedule() (startsAt, endsAt time.Time, err error) {
+	if c.StartsAt != "" {
+		if startsAt, err = time.Parse(time.RFC3339, c.StartsAt); err != nil {
+			return
+		}
+	}
+	if c.EndsAt != "" {
+		endsAt, err = time.Parse(time.RFC3339, c.EndsAt)
+	}
+	return
+}
+
+// JWTConf JWT 校验配置，投票人认证和管理接口各自一份
+type JWTConf struct {
+	Issuer       string        `yaml:"issuer" mapstructure:"issuer"`               // 校验 iss，为空时不校验
+	Audience     string        `yaml:"audience" mapstructure:"audience"`           // 校验 aud，为空时不校验
+	JWKSURL      string        `yaml:"jwks_url" mapstructure:"jwks_url"`           // 身份提供方的 JWKS 地址，用于校验 RS256/ES256 签名
+	JWKSRefresh  time.Duration `yaml:"jwks_refresh" mapstructure:"jwks_refresh"`   // JWKS 缓存时间，遇到未知的 kid 时也会提前刷新
+	HMACSecret   Secret        `yaml:"hmac_secret" mapstructure:"hmac_secret"`     // HS256 共享密钥，不使用身份提供方时自己签发 JWT
+	SubjectClaim string        `yaml:"subject_claim" mapstructure:"subject_claim"` // 作为用户标识的 claim，默认 sub
+}
+
+// AuthConf 投票人认证配置，开启后获取票据和投票都需要携带 JWT，票据与投票人绑定
+type AuthConf struct {
+	Enabled      bool `yaml:"enabled" mapstructure:"enabled"`
+	JWTConf      `yaml:",inline" mapstructure:",squash"`
+	TicketSecret Secret `yaml:"ticket_secret" mapstructure:"ticket_secret"` // 绑定票据与投票人的密钥，所有实例需要一致
+	VoterUses    int    `yaml:"voter_uses" mapstructure:"voter_uses"`       // 每个投票人使用同一张票据的最大次数，与票据的最大使用次数同时生效
+}
+
+// AdminConf 管理接口配置，管理接口单独监听一个地址，只应暴露在内网
+type AdminConf struct {
+	Enabled   bool   `yaml:"enabled" mapstructure:"enabled"`
+	Addr      string `yaml:"addr" mapstructure:"addr"` // 监听地址
+	JWTConf   `yaml:",inline" mapstructure:",squash"`
+	RoleClaim string `yaml:"role_claim" mapstructure:"role_claim"` // 角色所在的 claim，值为 viewer、operator 或 admin
+}
+
+// TraceConf 链路追踪配置
+type TraceConf struct {
+	Exporter    string  `yaml:"exporter" mapstructure:"exporter"`         // 导出方式：none、otlp、stdout
+	Endpoint    string  `yaml:"endpoint" mapstructure:"endpoint"`         // otlp collector 地址，例如 localhost:4318
+	Insecure    bool    `yaml:"insecure" mapstructure:"insecure"`         // 是否使用 http 而不是 https 连接 collector
+	SampleRatio float64 `yaml:"sample_ratio" mapstructure:"sample_ratio"` // 采样比例，0 到 1，上游已采样的请求总是采样
+}
+
+// LogConf 日志配置
+type LogConf struct {
+	Level  string `yaml:"level" mapstructure:"level"`   // 日志级别：debug、info、warn、error
+	Format string `yaml:"format" mapstructure:"format"` // 日志格式：text 或 json
+}
+
+// AppConf 应用配置
+type AppConf struct {
+	AppName         string        `yaml:"app_name" mapstructure:"app_name"`                 // 应用名称
+	Host            string        `yaml:"host" mapstructure:"host"`                         // 服务监听地址，为空时监听所有网卡
+	Port            int           `yaml:"port" mapstructure:"port"`                         // 服务启用端口
+	Pprof           bool          `yaml:"pprof" mapstructure:"pprof"`                       // 是否开启 pprof
+	PprofAddr       string        `yaml:"pprof_addr" mapstructure:"pprof_addr"`             // pprof 监听地址
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" mapstructure:"shutdown_timeout"` // 优雅关闭的最长等待时间
+	ShutdownDrain   time.Duration `yaml:"shutdown_drain" mapstructure:"shutdown_drain"`     // 开始关闭后 /readyz 返回 503，等待负载均衡摘除实例的时间
+	StartupTimeout  time.Duration `yaml:"startup_timeout" mapstructure:"startup_timeout"`   // 启动时等待 mysql 和 redis 可用的最长时间
+}
+
+// Addr 返回服务监听地址
+func (c AppConf) Addr() string {
+	return c.Host + ":" + strconv.Itoa(c.Port)
 }
 
 type DbConf struct {
 	Host        string `yaml:"host" mapstructure:"host"`                   // 主机地址
 	Port        string `yaml:"port" mapstructure:"port"`                   // 端口号
 	User        string `yaml:"user" mapstructure:"user"`                   // 用户名
-	Password    string `yaml:"password" mapstructure:"password"`           // 密码
+	Password    Secret `yaml:"password" mapstructure:"password"`           // 密码，支持 env:NAME 和 file:/path 引用
 	Dbname      string `yaml:"dbname" mapstructure:"dbname"`               // 数据库名
 	MaxIdleConn int    `yaml:"max_idle_conn" mapstructure:"max_idle_conn"` // 最大空闲连接数
 	MaxOpenConn int    `yaml:"max_open_conn" mapstructure:"max_open_conn"` // 最大打开连接数
@@ -45,68 +170,94 @@ type RedisConf struct {
 	Host        string `yaml:"rhost" mapstructure:"rhost"`                   // db主机地址
 	Port        int    `yaml:"rport" mapstructure:"rport"`                   // db端口
 	DB          int    `yaml:"rdb" mapstructure:"rdb"`                       // 数据库
-	PassWord    string `yaml:"passwd" mapstructure:"passwd"`                 // 密码
+	PassWord    Secret `yaml:"passwd" mapstructure:"passwd"`                 // 密码，支持 env:NAME 和 file:/path 引用
 	PoolSile    int    `yaml:"poolsize" mapstructure:"poolsize"`             // 连接池大小，即最大连接数
 	MinIdleConn int    `yaml:"min_idle_coons" mapstructure:"min_idle_coons"` // 最小空闲连接
+	KeyPrefix   string `yaml:"key_prefix" mapstructure:"key_prefix"`         // 键命名空间前缀，区分环境/租户
 }
 
+// GetGlobalConf 返回全局配置，配置加载失败时 panic，启动时应先调用 Load 处理错误
 func GetGlobalConf() *GlobalConfig {
-	once.Do(readConf)
+	if err := Load(); err != nil {
+		panic(err)
+	}
 	return &config
 }
 
+// Load 读取并校验配置，只会执行一次，之后返回第一次加载的结果
+func Load() error {
+	once.Do(func() { loadErr = readConf() })
+	return loadErr
+}
+
 // 将配置文件中的信息全部加载到 全局配置文件中
-func readConf() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-	viper.AddConfigPath("../config")
+func readConf() error {
+	setDefaults(viper.GetViper())
+	bindEnv(viper.GetViper())
+	if configFile == "" {
+		configFile = os.Getenv(EnvPrefix + "_CONFIG")
+	}
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yml")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("./config")
+		viper.AddConfigPath("../config")
+	}
 	err := viper.ReadInConfig() // 读取配置信息
 	if err != nil {
-		panic("read config file err:" + err.Error())
+		return fmt.Errorf("read config file err: %w", err)
 	}
 	err = viper.Unmarshal(&config) // 将配置信息反序列化填充到全局配置文件中
 	if err != nil {
-		panic("config file unmarshal err:" + err.Error())
+		return fmt.Errorf("config file unmarshal err: %w", err)
+	}
+	if err := resolveSecrets(&config); err != nil {
+		return fmt.Errorf("config file %s has unresolvable secrets: %w", viper.ConfigFileUsed(), err)
+	}
+	s, err := loadSettings(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("config file %s is invalid: %w", viper.ConfigFileUsed(), err)
+	}
+	if err := Validate(&config, s); err != nil {
+		return fmt.Errorf("config file %s is invalid: %w", viper.ConfigFileUsed(), err)
 	}
 	log.Infof("config === %+v\n", config)
 
-	MaxVotes = viper.GetInt("maxVotes")
-	TicketsUpdateTime = viper.GetDuration("ticketUpdateTime")
-	TicketCacheRefreshTime = viper.GetDuration("ticketCacheRefreshTime")
-	VotesCacheToDbTime = viper.GetDuration("votesCacheToDbTime")
-	TicketLen = viper.GetInt("ticketLen")
-	MinIdleCoons = viper.GetInt("min_idle_coons")
-	GoGC = viper.GetInt("goGc")
-	fmt.Printf("票据最大使用次数：%d, 票据更新时间：%fs，票数缓存失效时间：%fs，"+
-		"redis投票数据多久刷盘一次：%f，票据长度：%d ，redis 最小空闲连接数：%d\n",
-		MaxVotes, TicketsUpdateTime.Seconds(), TicketCacheRefreshTime.Seconds(),
-		VotesCacheToDbTime.Seconds(), TicketLen, MinIdleCoons)
+	publish(s)
+	log.Infof("运行时配置：%s", Current())
+	return nil
+}
+
+// Watch 监听配置文件的变化，热更运行时配置
+func Watch() {
 	viper.WatchConfig() //监听配置文件的变化
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		if updateDebounceTimer != nil {
 			updateDebounceTimer.Stop()
 		}
-		updateDebounceTimer = time.AfterFunc(debounceDuration, func() {
-			viper.ReadInConfig() //重新加载
-			fmt.Println(fmt.Sprintf("%s --- %s", time.Now(), "更新配置项"))
-			MaxVotes = viper.GetInt("maxVotes")
-			TicketsUpdateTime = viper.GetDuration("ticketUpdateTime")
-			TicketCacheRefreshTime = viper.GetDuration("ticketCacheRefreshTime")
-			VotesCacheToDbTime = viper.GetDuration("votesCacheToDbTime")
-			TicketLen = viper.GetInt("ticketLen")
-			MinIdleCoons = viper.GetInt("min_idle_coons")
-			GoGC = viper.GetInt("goGc")
-			fmt.Printf("票据最大使用次数：%d, 票据更新时间：%fs，票数缓存失效时间：%fs，"+
-				"redis投票数据多久刷盘一次：%f，票据长度：%d ，redis 最小空闲连接数：%d\n",
-				MaxVotes, TicketsUpdateTime.Seconds(), TicketCacheRefreshTime.Seconds(),
-				VotesCacheToDbTime.Seconds(), TicketLen, MinIdleCoons)
-		})
+		updateDebounceTimer = time.AfterFunc(debounceDuration, reloadSettings)
 	})
 }
 
-func init() {
-	globalConf := GetGlobalConf() // 获取全局配置文件
-	fmt.Println(globalConf)
+// reloadSettings 重新读取配置文件，生成新的运行时配置快照并通知订阅者
+// 新配置校验不通过时保留原有配置
+func reloadSettings() {
+	if err := viper.ReadInConfig(); err != nil { //重新加载
+		log.Errorf("reload config file err: %v", err)
+		return
+	}
+	s, err := loadSettings(viper.GetViper())
+	if err != nil {
+		log.Errorf("reject invalid config, keep version %d: %v", Current().Version, err)
+		return
+	}
+	if err := s.Validate(); err != nil {
+		log.Errorf("reject invalid config, keep version %d: %v", Current().Version, err)
+		return
+	}
+	publish(s)
+	log.Infof("更新配置项，%s", Current())
 }
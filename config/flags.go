@@ -0,0 +1,230 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+// EnvPrefix 环境变量前缀，配置项中的 "." 替换为 "_"，例如 db.password 对应 VOTEME_DB_PASSWORD
+const EnvPrefix = "VOTEME"
+
+// configFile 通过 --config 或 VOTEME_CONFIG 指定的配置文件路径，为空时按默认路径查找
+var configFile string
+
+// 命令行参数与配置项的对应关系
+var flagKeys = map[string]string{
+	"app-name":                  "app.app_name",
+	"host":                      "app.host",
+	"port":                      "app.port",
+	"pprof":                     "app.pprof",
+	"pprof-addr":                "app.pprof_addr",
+	"shutdown-timeout":          "app.shutdown_timeout",
+	"shutdown-drain":            "app.shutdown_drain",
+	"startup-timeout":           "app.startup_timeout",
+	"db-host":                   "db.host",
+	"db-port":                   "db.port",
+	"db-user":                   "db.user",
+	"db-name":                   "db.dbname",
+	"db-max-idle-conn":          "db.max_idle_conn",
+	"db-max-open-conn":          "db.max_open_conn",
+	"db-max-idle-time":          "db.max_idle_time",
+	"redis-host":                "redis.rhost",
+	"redis-port":                "redis.rport",
+	"redis-db":                  "redis.rdb",
+	"redis-pool-size":           "redis.poolsize",
+	"redis-min-idle-conn":       "redis.min_idle_coons",
+	"redis-key-prefix":          "redis.key_prefix",
+	"log-level":                 "log.level",
+	"log-format":                "log.format",
+	"trace-exporter":            "trace.exporter",
+	"trace-endpoint":            "trace.endpoint",
+	"trace-insecure":            "trace.insecure",
+	"trace-sample-ratio":        "trace.sample_ratio",
+	"auth":                      "auth.enabled",
+	"auth-issuer":               "auth.issuer",
+	"auth-audience":             "auth.audience",
+	"auth-jwks-url":             "auth.jwks_url",
+	"auth-voter-uses":           "auth.voter_uses",
+	"admin":                     "admin.enabled",
+	"admin-addr":                "admin.addr",
+	"contest-starts-at":         "contest.starts_at",
+	"contest-ends-at":           "contest.ends_at",
+	"ledger":                    "ledger.enabled",
+	"ticket-retention":          "tickets.retention",
+	"voting-mode":               "voting.mode",
+	"max-votes":                 "maxVotes",
+	"ticket-update-time":        "ticketUpdateTime",
+	"ticket-len":                "ticketLen",
+	"ticket-cache-refresh-time": "ticketCacheRefreshTime",
+	"votes-cache-to-db-time":    "votesCacheToDbTime",
+	"go-gc":                     "goGc",
+	"redis-timeout":             "timeout.redis",
+	"mysql-timeout":             "timeout.mysql",
+	"lock-wait-timeout":         "timeout.lockWait",
+	"sync-votes-timeout":        "timeout.syncVotes",
+	"max-sync-lag":              "health.maxSyncLag",
+	"challenge":                 "challenge.enabled",
+	"challenge-difficulty":      "challenge.difficulty",
+	"challenge-ttl":             "challenge.ttl",
+	"challenge-ticket-uses":     "challenge.ticketUses",
+}
+
+// setDefaults 设置所有配置项的默认值，配置文件中没有出现的项也能被环境变量覆盖
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("app.app_name", "Voteme")
+	v.SetDefault("app.host", "")
+	v.SetDefault("app.port", 9090)
+	v.SetDefault("app.pprof", true)
+	v.SetDefault("app.pprof_addr", "localhost:6060")
+	v.SetDefault("app.shutdown_timeout", 30*time.Second)
+	v.SetDefault("app.shutdown_drain", 5*time.Second)
+	v.SetDefault("app.startup_timeout", 30*time.Second)
+	v.SetDefault("db.host", "127.0.0.1")
+	v.SetDefault("db.port", "3306")
+	v.SetDefault("db.user", "root")
+	v.SetDefault("db.password", "")
+	v.SetDefault("db.dbname", "voteme")
+	v.SetDefault("db.max_idle_conn", 10)
+	v.SetDefault("db.max_open_conn", 100)
+	v.SetDefault("db.max_idle_time", 300)
+	v.SetDefault("redis.rhost", "127.0.0.1")
+	v.SetDefault("redis.rport", 6379)
+	v.SetDefault("redis.rdb", 0)
+	v.SetDefault("redis.passwd", "")
+	v.SetDefault("redis.poolsize", 100)
+	v.SetDefault("redis.min_idle_coons", 10)
+	v.SetDefault("redis.key_prefix", "Voteme")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "text")
+	v.SetDefault("trace.exporter", "none")
+	v.SetDefault("trace.endpoint", "localhost:4318")
+	v.SetDefault("trace.insecure", true)
+	v.SetDefault("trace.sample_ratio", 1.0)
+	v.SetDefault("auth.enabled", false)
+	v.SetDefault("auth.issuer", "")
+	v.SetDefault("auth.audience", "")
+	v.SetDefault("auth.jwks_url", "")
+	v.SetDefault("auth.jwks_refresh", 10*time.Minute)
+	v.SetDefault("auth.hmac_secret", "")
+	v.SetDefault("auth.ticket_secret", "")
+	v.SetDefault("auth.subject_claim", "sub")
+	v.SetDefault("auth.voter_uses", 1)
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.addr", "localhost:9091")
+	v.SetDefault("admin.issuer", "")
+	v.SetDefault("admin.audience", "")
+	v.SetDefault("admin.jwks_url", "")
+	v.SetDefault("admin.jwks_refresh", 10*time.Minute)
+	v.SetDefault("admin.hmac_secret", "")
+	v.SetDefault("admin.subject_claim", "sub")
+	v.SetDefault("admin.role_claim", "role")
+	v.SetDefault("contest.starts_at", "")
+	v.SetDefault("contest.ends_at", "")
+	v.SetDefault("results.signing_key", "")
+	v.SetDefault("tickets.retention", 30*24*time.Hour)
+	v.SetDefault("tickets.prune_interval", time.Hour)
+	v.SetDefault("ledger.enabled", false)
+	v.SetDefault("ledger.checkpoint_interval", 5*time.Minute)
+	v.SetDefault("voting.mode", VotingApproval)
+	v.SetDefault("voting.min_selections", 0)
+	v.SetDefault("voting.max_selections", 0)
+	v.SetDefault("voting.allow_duplicates", false)
+	v.SetDefault("voting.points", []int{3, 2, 1})
+	v.SetDefault("maxVotes", 100000)
+	v.SetDefault("ticketUpdateTime", 2*time.Second)
+	v.SetDefault("ticketLen", 10)
+	v.SetDefault("ticketCacheRefreshTime", 2*time.Second)
+	v.SetDefault("votesCacheToDbTime", 2*time.Second)
+	v.SetDefault("goGc", 100)
+	v.SetDefault("timeout.redis", 500*time.Millisecond)
+	v.SetDefault("timeout.mysql", 3*time.Second)
+	v.SetDefault("timeout.lockWait", 10*time.Second)
+	v.SetDefault("timeout.syncVotes", 30*time.Second)
+	v.SetDefault("health.maxSyncLag", time.Minute)
+	v.SetDefault("rateLimit.enabled", false)
+	v.SetDefault("challenge.enabled", false)
+	v.SetDefault("challenge.difficulty", 20)
+	v.SetDefault("challenge.ttl", time.Minute)
+	v.SetDefault("challenge.ticketUses", 1)
+}
+
+// bindEnv 让环境变量覆盖配置文件，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+func bindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+}
+
+// ParseFlags 解析命令行参数，需要在第一次调用 GetGlobalConf 之前执行
+func ParseFlags(args []string) error {
+	fs := pflag.NewFlagSet("voteme", pflag.ContinueOnError)
+	fs.StringVar(&configFile, "config", "", "配置文件路径，默认依次查找 ./config.yml、./config/config.yml、../config/config.yml")
+
+	fs.String("app-name", "", "应用名称")
+	fs.String("host", "", "服务监听地址")
+	fs.Int("port", 0, "服务监听端口")
+	fs.Bool("pprof", true, "是否开启 pprof")
+	fs.String("pprof-addr", "", "pprof 监听地址")
+	fs.Duration("shutdown-timeout", 0, "优雅关闭的最长等待时间")
+	fs.Duration("shutdown-drain", 0, "开始关闭后等待负载均衡摘除实例的时间")
+	fs.Duration("startup-timeout", 0, "启动时等待 mysql 和 redis 可用的最长时间")
+	fs.String("db-host", "", "mysql 主机地址")
+	fs.String("db-port", "", "mysql 端口号")
+	fs.String("db-user", "", "mysql 用户名")
+	fs.String("db-name", "", "mysql 数据库名")
+	fs.Int("db-max-idle-conn", 0, "mysql 最大空闲连接数")
+	fs.Int("db-max-open-conn", 0, "mysql 最大连接数")
+	fs.Int64("db-max-idle-time", 0, "mysql 连接最大空闲时间（秒）")
+	fs.String("redis-host", "", "redis 主机地址")
+	fs.Int("redis-port", 0, "redis 端口")
+	fs.Int("redis-db", 0, "redis 数据库")
+	fs.Int("redis-pool-size", 0, "redis 连接池大小")
+	fs.Int("redis-min-idle-conn", 0, "redis 最小空闲连接数")
+	fs.String("redis-key-prefix", "", "redis 键命名空间前缀")
+	fs.String("log-level", "", "日志级别：debug、info、warn、error")
+	fs.String("log-format", "", "日志格式：text 或 json")
+	fs.String("trace-exporter", "", "链路追踪导出方式：none、otlp、stdout")
+	fs.String("trace-endpoint", "", "otlp collector 地址")
+	fs.Bool("trace-insecure", true, "是否使用 http 连接 collector")
+	fs.Float64("trace-sample-ratio", 0, "链路追踪采样比例，0 到 1")
+	fs.Bool("auth", false, "是否要求投票人携带 JWT")
+	fs.String("auth-issuer", "", "JWT 签发方")
+	fs.String("auth-audience", "", "JWT 受众")
+	fs.String("auth-jwks-url", "", "身份提供方的 JWKS 地址")
+	fs.Int("auth-voter-uses", 0, "每个投票人使用同一张票据的最大次数")
+	fs.Bool("admin", false, "是否开启管理接口")
+	fs.String("admin-addr", "", "管理接口监听地址")
+	fs.String("contest-starts-at", "", "投票活动计划开始时间，RFC3339 格式")
+	fs.String("contest-ends-at", "", "投票活动计划结束时间，RFC3339 格式")
+	fs.Bool("ledger", false, "是否开启刷盘账本")
+	fs.Duration("ticket-retention", 0, "票据记录保留多久，0 表示永久保留")
+	fs.String("voting-mode", "", "投票方式：single、approval、weighted、ranked")
+	fs.Int("max-votes", 0, "一个票据最大投票次数")
+	fs.Duration("ticket-update-time", 0, "一个票据的失效时间")
+	fs.Int("ticket-len", 0, "票据长度")
+	fs.Duration("ticket-cache-refresh-time", 0, "票数缓存刷新时间")
+	fs.Duration("votes-cache-to-db-time", 0, "redis 中的投票数据多久刷盘一次")
+	fs.Int("go-gc", 0, "go 程序 gc 步调")
+	fs.Duration("redis-timeout", 0, "单次 redis 操作的超时时间")
+	fs.Duration("mysql-timeout", 0, "单次 mysql 操作的超时时间")
+	fs.Duration("lock-wait-timeout", 0, "等待分布式锁的最长时间")
+	fs.Duration("sync-votes-timeout", 0, "一次刷盘的最长时间")
+	fs.Duration("max-sync-lag", 0, "超过多久没有成功刷盘，/readyz 返回未就绪")
+	fs.Bool("challenge", false, "获取票据前是否需要先完成工作量证明")
+	fs.Int("challenge-difficulty", 0, "工作量证明的难度，sha256 结果前导零的位数")
+	fs.Duration("challenge-ttl", 0, "工作量证明挑战的有效期")
+	fs.Int("challenge-ticket-uses", 0, "解出一个挑战换到的票据可以投票的次数")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// 只绑定显式传入的参数，未传入的参数不会覆盖配置文件
+	for name, key := range flagKeys {
+		if err := viper.BindPFlag(key, fs.Lookup(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RateLimitOperations 可以配置限流的 GraphQL 操作
+var RateLimitOperations = []string{"vote", "getCurrentTicket", "getUserVotes", "getChallenge", "getResults"}
+
+// RateLimit 单个操作的限流规则，在 Window 时间内最多 PerIP / PerTicket 次，为 0 时不限制
+type RateLimit struct {
+	Window    time.Duration `mapstructure:"window"`    // 滑动窗口大小
+	PerIP     int           `mapstructure:"perIP"`     // 每个客户端 IP 的次数
+	PerTicket int           `mapstructure:"perTicket"` // 每张票据的次数，只对带 ticket 参数的操作生效
+}
+
+// RateLimitSettings 限流配置
+type RateLimitSettings struct {
+	Enabled        bool                 `mapstructure:"enabled"`
+	TrustedProxies []string             `mapstructure:"trustedProxies"` // 可信代理的 IP 或 CIDR，只有来自这些地址的 X-Forwarded-For 才会被采信
+	Operations     map[string]RateLimit `mapstructure:"operations"`     // 操作名到限流规则
+}
+
+// Rule 返回操作的限流规则，没有配置时返回 false
+// viper 读取的键都是小写的，这里忽略大小写查找
+func (r RateLimitSettings) Rule(operation string) (RateLimit, bool) {
+	for name, rule := range r.Operations {
+		if strings.EqualFold(name, operation) {
+			return rule, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+// ParseTrustedProxies 解析可信代理列表，单个 IP 视为 /32 或 /128
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy cidr %q", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (r RateLimitSettings) validate(v *validator) {
+	_, err := ParseTrustedProxies(r.TrustedProxies)
+	v.check(err == nil, "rateLimit.trustedProxies", r.TrustedProxies, "must be ip addresses or cidrs")
+	for name, rule := range r.Operations {
+		field := "rateLimit.operations." + name
+		known := false
+		for _, op := range RateLimitOperations {
+			known = known || strings.EqualFold(op, name)
+		}
+		v.check(known, field, name, "must be one of "+strings.Join(RateLimitOperations, ", "))
+		v.check(rule.PerIP >= 0, field+".perIP", rule.PerIP, "must be >= 0, 0 means unlimited")
+		v.check(rule.PerTicket >= 0, field+".perTicket", rule.PerTicket, "must be >= 0, 0 means unlimited")
+		if rule.PerIP > 0 || rule.PerTicket > 0 {
+			v.check(rule.Window >= time.Millisecond, field+".window", rule.Window, "must be at least 1ms")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted      = "******"
+	envSecretRef  = "env:"  // 从环境变量读取，例如 env:MYSQL_PASSWORD
+	fileSecretRef = "file:" // 从文件读取，例如 file:/run/secrets/mysql-password，适合挂载 Kubernetes secret
+)
+
+// Secret 密码等敏感配置，打印和序列化时一律脱敏，需要明文时调用 Reveal
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString 保证 %#v 也不会打印明文
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// Reveal 返回明文，只应在建立连接时使用
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// secretFields 所有敏感配置项，key 与配置文件中的写法一致
+func secretFields(c *GlobalConfig) map[string]*Secret {
+	return map[string]*Secret{
+		"db.password":         &c.DbConfig.Password,
+		"redis.passwd":        &c.RedisConfig.PassWord,
+		"auth.hmac_secret":    &c.AuthConfig.HMACSecret,
+		"auth.ticket_secret":  &c.AuthConfig.TicketSecret,
+		"admin.hmac_secret":   &c.AdminConfig.HMACSecret,
+		"results.signing_key": &c.ResultsConfig.SigningKey,
+	}
+}
+
+// resolveSecret 解析密钥引用，返回明文以及引用的文件路径（不是文件引用时为空）
+// 不带 env: 或 file: 前缀的值按明文处理
+func resolveSecret(ref string) (Secret, string, error) {
+	switch {
+	case strings.HasPrefix(ref, envSecretRef):
+		name := strings.TrimPrefix(ref, envSecretRef)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return Secret(value), "", nil
+	case strings.HasPrefix(ref, fileSecretRef):
+		path := strings.TrimPrefix(ref, fileSecretRef)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", path, err
+		}
+		return Secret(strings.TrimRight(string(content), "\r\n")), path, nil
+	}
+	return Secret(ref), "", nil
+}
+
+// resolveSecrets 将配置中的密钥引用替换为明文，校验错误一次性返回
+func resolveSecrets(c *GlobalConfig) error {
+	v := &validator{}
+	for field, secret := range secretFields(c) {
+		value, _, err := resolveSecret(string(*secret))
+		if err != nil {
+			v.check(false, field, string(*secret), "cannot resolve secret: "+err.Error())
+			continue
+		}
+		*secret = value
+	}
+	return v.err()
+}
+
+var (
+	secretsMu       sync.Mutex
+	secretFiles     = map[string]string{} // 配置项 -> 引用的文件路径
+	secretValues    = map[string]Secret{} // 配置项 -> 当前明文
+	secretListeners []func(field string, value Secret)
+)
+
+// OnSecretChange 注册密钥变化的回调，引用的文件内容变化时调用，例如 Kubernetes 更新了挂载的 secret
+// 回调在监听文件的 goroutine 中执行，field 为配置项，例如 db.password
+func OnSecretChange(fn func(field string, value Secret)) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secretListeners = append(secretListeners, fn)
+}
+
+// WatchSecrets 监听所有以 file: 引用的密钥文件
+// 监听的是文件所在目录，Kubernetes 通过替换符号链接更新 secret，直接监听文件会丢失事件
+func WatchSecrets() error {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	dirs := map[string]bool{}
+	for field := range secretFields(&GlobalConfig{}) {
+		value, path, err := resolveSecret(viper.GetString(field))
+		if err != nil || path == "" {
+			continue
+		}
+		secretFiles[field] = path
+		secretValues[field] = value
+		dirs[filepath.Dir(path)] = true
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("watch secret dir %s: %w", dir, err)
+		}
+	}
+	go func() {
+		var debounce *time.Timer
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(debounceDuration, reloadSecrets)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("watch secret files err: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadSecrets 重新读取密钥文件，内容变化时通知回调
+func reloadSecrets() {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for field, path := range secretFiles {
+		value, _, err := resolveSecret(fileSecretRef + path)
+		if err != nil {
+			log.Errorf("reload secret %s err: %v", field, err)
+			continue
+		}
+		if value == secretValues[field] {
+			continue
+		}
+		secretValues[field] = value
+		log.Infof("secret %s changed, reloading", field)
+		for _, fn := range secretListeners {
+			fn(field, value)
+		}
+	}
+}
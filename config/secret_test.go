@@ -0,0 +1,40 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 测试密码在日志和 json 输出中都会脱敏
+func TestSecretRedaction(t *testing.T) {
+	c := GlobalConfig{DbConfig: DbConf{Password: "123456"}, RedisConfig: RedisConf{PassWord: "abcdef"}}
+	for _, out := range []string{fmt.Sprintf("%v", c), fmt.Sprintf("%+v", c), fmt.Sprintf("%#v", c)} {
+		assert.NotContains(t, out, "123456")
+		assert.NotContains(t, out, "abcdef")
+	}
+	data, err := json.Marshal(c)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "123456")
+	assert.Equal(t, "123456", c.DbConfig.Password.Reveal())
+}
+
+func TestResolveSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis-password")
+	assert.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0600))
+	t.Setenv("TEST_DB_PASSWORD", "from-env")
+
+	c := GlobalConfig{
+		DbConfig:    DbConf{Password: "env:TEST_DB_PASSWORD"},
+		RedisConfig: RedisConf{PassWord: Secret("file:" + path)},
+	}
+	assert.NoError(t, resolveSecrets(&c))
+	assert.Equal(t, "from-env", c.DbConfig.Password.Reveal())
+	assert.Equal(t, "from-file", c.RedisConfig.PassWord.Reveal())
+
+	c = GlobalConfig{DbConfig: DbConf{Password: "env:TEST_MISSING_PASSWORD"}}
+	assert.Error(t, resolveSecrets(&c))
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings 运行时可热更的配置快照
+// 快照创建后只读，热更时整体替换，读取方拿到的总是一份完整一致的配置
+type Settings struct {
+	Version                int64             // 配置版本号，每次热更加一
+	LoadedAt               time.Time         // 加载时间
+	MaxVotes               int               // 票据最大使用次数
+	TicketsUpdateTime      time.Duration     // 票据更新时间
+	TicketCacheRefreshTime time.Duration     // 票数缓存刷新时间
+	VotesCacheToDbTime     time.Duration     // redis中缓存数据的刷盘时间
+	TicketLen              int               // 票据长度
+	GoGC                   int               // GoGc 步调
+	RedisTimeout           time.Duration     // 单次 redis 操作的超时时间
+	MysqlTimeout           time.Duration     // 单次 mysql 操作的超时时间
+	LockWaitTimeout        time.Duration     // 等待分布式锁的最长时间
+	SyncVotesTimeout       time.Duration     // 一次刷盘的最长时间
+	MaxSyncLag             time.Duration     // 超过多久没有成功刷盘，实例就不再是就绪状态
+	RateLimit              RateLimitSettings // 接口限流
+	ChallengeEnabled       bool              // 获取票据前是否需要先完成工作量证明
+	ChallengeDifficulty    int               // 工作量证明的难度，sha256 结果前导零的位数
+	ChallengeTTL           time.Duration     // 挑战的有效期
+	ChallengeTicketUses    int               // 解出一个挑战换到的票据可以投票的次数
+}
+
+func (s *Settings) String() string {
+	return fmt.Sprintf("版本：%d，票据最大使用次数：%d, 票据更新时间：%fs，票数缓存失效时间：%fs，"+
+		"redis投票数据多久刷盘一次：%fs，票据长度：%d，gc 步调：%d，超时时间：redis %s，mysql %s，等待锁 %s，刷盘 %s，最大刷盘延迟：%s",
+		s.Version, s.MaxVotes, s.TicketsUpdateTime.Seconds(), s.TicketCacheRefreshTime.Seconds(),
+		s.VotesCacheToDbTime.Seconds(), s.TicketLen, s.GoGC,
+		s.RedisTimeout, s.MysqlTimeout, s.LockWaitTimeout, s.SyncVotesTimeout, s.MaxSyncLag)
+}
+
+var (
+	settings    atomic.Pointer[Settings] // 当前生效的配置快照
+	settingsMu  sync.Mutex               // 保证版本号递增和订阅者通知的顺序
+	version     int64                    // 最新的版本号
+	subscribers []subscriber             // 配置变更回调
+	nextSubID   int
+)
+
+type subscriber struct {
+	id int
+	fn func(old, new *Settings)
+}
+
+// Current 返回当前生效的配置快照，读取无锁
+func Current() *Settings {
+	if s := settings.Load(); s != nil {
+		return s
+	}
+	GetGlobalConf()
+	return settings.Load()
+}
+
+// Subscribe 注册配置变更回调，返回取消订阅的函数
+// 回调在热更的 goroutine 中按注册顺序同步执行，不应阻塞
+func Subscribe(fn func(old, new *Settings)) (unsubscribe func()) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	id := nextSubID
+	nextSubID++
+	subscribers = append(subscribers, subscriber{id: id, fn: fn})
+	return func() {
+		settingsMu.Lock()
+		defer settingsMu.Unlock()
+		for i, sub := range subscribers {
+			if sub.id == id {
+				subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Notify 配置变更时向返回的 channel 发送最新快照，channel 只保留最新的一份，ctx 取消后停止通知
+// 适合在后台任务的 select 循环中使用
+func Notify(ctx context.Context) <-chan *Settings {
+	ch := make(chan *Settings, 1)
+	unsubscribe := Subscribe(func(_, s *Settings) {
+		select {
+		case <-ch: // 丢弃还没来得及处理的旧快照
+		default:
+		}
+		ch <- s
+	})
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+	}()
+	return ch
+}
+
+// loadSettings 从 viper 中读取运行时配置
+func loadSettings(v *viper.Viper) (*Settings, error) {
+	s := &Settings{
+		LoadedAt:               time.Now(),
+		MaxVotes:               v.GetInt("maxVotes"),
+		TicketsUpdateTime:      v.GetDuration("ticketUpdateTime"),
+		TicketCacheRefreshTime: v.GetDuration("ticketCacheRefreshTime"),
+		VotesCacheToDbTime:     v.GetDuration("votesCacheToDbTime"),
+		TicketLen:              v.GetInt("ticketLen"),
+		GoGC:                   v.GetInt("goGc"),
+		RedisTimeout:           v.GetDuration("timeout.redis"),
+		MysqlTimeout:           v.GetDuration("timeout.mysql"),
+		LockWaitTimeout:        v.GetDuration("timeout.lockWait"),
+		SyncVotesTimeout:       v.GetDuration("timeout.syncVotes"),
+		MaxSyncLag:             v.GetDuration("health.maxSyncLag"),
+		ChallengeEnabled:       v.GetBool("challenge.enabled"),
+		ChallengeDifficulty:    v.GetInt("challenge.difficulty"),
+		ChallengeTTL:           v.GetDuration("challenge.ttl"),
+		ChallengeTicketUses:    v.GetInt("challenge.ticketUses"),
+	}
+	if err := v.UnmarshalKey("rateLimit", &s.RateLimit); err != nil {
+		return nil, fmt.Errorf("rateLimit: %w", err)
+	}
+	return s, nil
+}
+
+// publish 分配版本号并替换当前快照，然后通知所有订阅者
+func publish(s *Settings) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	publishLocked(s)
+}
+
+// publishLocked 同 publish，调用方需要持有 settingsMu
+func publishLocked(s *Settings) {
+	version++
+	s.Version = version
+	old := settings.Swap(s)
+	if old == nil {
+		return
+	}
+	for _, sub := range subscribers {
+		sub.fn(old, s)
+	}
+}
+
+// Update 在当前快照的副本上修改配置，校验通过后发布为新版本，用于管理接口在运行时调整配置
+// 只对当前实例生效，配置文件热更后会被文件中的值覆盖
+// 读取、修改、校验和发布期间持有 settingsMu，并发的更新不会互相覆盖
+func Update(fn func(s *Settings)) (*Settings, error) {
+	Current() // 首次加载配置时会调用 publish，需要在加锁之前完成
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	s := *settings.Load()
+	s.LoadedAt = time.Now()
+	fn(&s)
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	publishLocked(&s)
+	log.Infof("更新配置项，%s", &s)
+	return &s, nil
+}
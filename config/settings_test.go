@@ -0,0 +1,61 @@
+package config
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试热更时版本号递增、订阅者收到新旧快照，并且 Notify 只保留最新的快照
+func TestPublishSettings(t *testing.T) {
+	publish(&Settings{MaxVotes: 1})
+	base := Current().Version
+
+	var olds, news []int
+	unsubscribe := Subscribe(func(old, new *Settings) {
+		olds = append(olds, old.MaxVotes)
+		news = append(news, new.MaxVotes)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := Notify(ctx)
+
+	publish(&Settings{MaxVotes: 2})
+	publish(&Settings{MaxVotes: 3})
+	assert.Equal(t, base+2, Current().Version)
+	assert.Equal(t, 3, Current().MaxVotes)
+	assert.Equal(t, []int{1, 2}, olds)
+	assert.Equal(t, []int{2, 3}, news)
+
+	select {
+	case s := <-changes:
+		assert.Equal(t, 3, s.MaxVotes)
+	case <-time.After(time.Second):
+		t.Fatal("no settings notified")
+	}
+
+	unsubscribe()
+	publish(&Settings{MaxVotes: 4})
+	assert.Equal(t, []int{2, 3}, news)
+}
+
+// 测试并发的 Update 不会互相覆盖，每次修改都基于上一次发布的快照
+func TestConcurrentUpdate(t *testing.T) {
+	publish(validSettings())
+	base := Current()
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Update(func(s *Settings) { s.MaxVotes++ })
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, base.MaxVotes+n, Current().MaxVotes)
+	assert.Equal(t, base.Version+n, Current().Version)
+}
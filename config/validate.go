@@ -0,0 +1,260 @@
+package config
+
+import (
+	"encoding/pem"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	minTicketLen = 6  // 票据太短容易被猜中
+	maxTicketLen = 64 // 票据太长没有意义，还会浪费 redis 内存
+
+	minSecretLen = 32 // HMAC 密钥的最小长度
+
+	minChallengeDifficulty = 1  // 难度为 0 时任何答案都能通过
+	maxChallengeDifficulty = 32 // 每加一位耗时翻倍，32 位时普通客户端已经很难在有效期内算出来
+)
+
+// FieldError 单个配置项的校验错误
+type FieldError struct {
+	Field  string      // 配置项，与配置文件中的写法一致，例如 db.max_open_conn
+	Value  interface{} // 当前的值
+	Reason string      // 不合法的原因以及如何修改
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s = %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// ValidationError 汇总所有不合法的配置项，一次性报告出来，避免改一个报一个
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Errors)+1)
+	lines = append(lines, fmt.Sprintf("%d invalid config field(s):", len(e.Errors)))
+	for _, fe := range e.Errors {
+		lines = append(lines, "  - "+fe.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validator 收集校验错误
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) check(ok bool, field string, value interface{}, reason string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Value: value, Reason: reason})
+	}
+}
+
+// rfc3339 校验可选的时间，为空时不校验
+func (v *validator) rfc3339(field, value string) {
+	if value == "" {
+		return
+	}
+	_, err := time.Parse(time.RFC3339, value)
+	v.check(err == nil, field, value, "must be an RFC3339 time such as 2024-05-01T20:00:00+08:00")
+}
+
+func (v *validator) port(field string, port int) {
+	v.check(port > 0 && port <= 65535, field, port, "must be a port between 1 and 65535")
+}
+
+func (v *validator) positiveDuration(field string, d time.Duration) {
+	v.check(d > 0, field, d, "must be a positive duration such as 2s or 500ms")
+}
+
+func (v *validator) notEmpty(field, value string) {
+	v.check(strings.TrimSpace(value) != "", field, value, "must not be empty")
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// Validate 校验启动配置和运行时配置，返回的 *ValidationError 包含所有不合法的配置项
+func Validate(c *GlobalConfig, s *Settings) error {
+	v := &validator{}
+	c.validate(v)
+	s.validate(v)
+	return v.err()
+}
+
+// Validate 校验启动配置
+func (c *GlobalConfig) Validate() error {
+	v := &validator{}
+	c.validate(v)
+	return v.err()
+}
+
+// Validate 校验运行时配置，热更时校验不通过的配置不会生效
+func (s *Settings) Validate() error {
+	v := &validator{}
+	s.validate(v)
+	return v.err()
+}
+
+func (c *GlobalConfig) validate(v *validator) {
+	app := c.AppConfig
+	v.port("app.port", app.Port)
+	if app.Pprof {
+		v.notEmpty("app.pprof_addr", app.PprofAddr)
+		v.check(app.PprofAddr != app.Addr(), "app.pprof_addr", app.PprofAddr, "must differ from app.host:app.port")
+	}
+	v.positiveDuration("app.shutdown_timeout", app.ShutdownTimeout)
+	v.check(app.ShutdownDrain >= 0 && app.ShutdownDrain < app.ShutdownTimeout, "app.shutdown_drain", app.ShutdownDrain,
+		"must not be negative and must be less than app.shutdown_timeout")
+	v.positiveDuration("app.startup_timeout", app.StartupTimeout)
+
+	dbConf := c.DbConfig
+	v.notEmpty("db.host", dbConf.Host)
+	v.notEmpty("db.port", dbConf.Port)
+	v.notEmpty("db.user", dbConf.User)
+	v.notEmpty("db.dbname", dbConf.Dbname)
+	v.check(dbConf.MaxOpenConn > 0, "db.max_open_conn", dbConf.MaxOpenConn, "must be greater than 0")
+	v.check(dbConf.MaxIdleConn >= 0 && dbConf.MaxIdleConn <= dbConf.MaxOpenConn, "db.max_idle_conn",
+		dbConf.MaxIdleConn, "must be between 0 and db.max_open_conn")
+	v.check(dbConf.MaxIdleTime >= 0, "db.max_idle_time", dbConf.MaxIdleTime, "must be seconds >= 0")
+
+	redisConf := c.RedisConfig
+	v.notEmpty("redis.rhost", redisConf.Host)
+	v.port("redis.rport", redisConf.Port)
+	v.check(redisConf.DB >= 0, "redis.rdb", redisConf.DB, "must be >= 0")
+	v.check(redisConf.PoolSile > 0, "redis.poolsize", redisConf.PoolSile, "must be greater than 0")
+	v.check(redisConf.MinIdleConn >= 0 && redisConf.MinIdleConn <= redisConf.PoolSile, "redis.min_idle_coons",
+		redisConf.MinIdleConn, "must be between 0 and redis.poolsize")
+	v.check(!strings.ContainsAny(redisConf.KeyPrefix, " \t\r\n"), "redis.key_prefix", redisConf.KeyPrefix,
+		"must not contain whitespace")
+
+	logConf := c.LogConfig
+	_, err := log.ParseLevel(logConf.Level)
+	v.check(err == nil, "log.level", logConf.Level, "must be one of trace, debug, info, warn, error")
+	v.check(logConf.Format == "text" || logConf.Format == "json", "log.format", logConf.Format, "must be text or json")
+
+	traceConf := c.TraceConfig
+	switch traceConf.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		v.notEmpty("trace.endpoint", traceConf.Endpoint)
+	default:
+		v.check(false, "trace.exporter", traceConf.Exporter, "must be one of none, otlp, stdout")
+	}
+	v.check(traceConf.SampleRatio >= 0 && traceConf.SampleRatio <= 1, "trace.sample_ratio", traceConf.SampleRatio,
+		"must be between 0 and 1")
+
+	authConf := c.AuthConfig
+	if authConf.Enabled {
+		authConf.JWTConf.validate(v, "auth")
+		v.check(len(authConf.TicketSecret) >= minSecretLen, "auth.ticket_secret", authConf.TicketSecret,
+			fmt.Sprintf("must be at least %d bytes, and the same on every instance", minSecretLen))
+		v.check(authConf.VoterUses > 0, "auth.voter_uses", authConf.VoterUses, "must be greater than 0")
+	}
+
+	adminConf := c.AdminConfig
+	if adminConf.Enabled {
+		adminConf.JWTConf.validate(v, "admin")
+		v.notEmpty("admin.addr", adminConf.Addr)
+		v.check(adminConf.Addr != app.Addr(), "admin.addr", adminConf.Addr, "must differ from app.host:app.port")
+		v.check(!app.Pprof || adminConf.Addr != app.PprofAddr, "admin.addr", adminConf.Addr, "must differ from app.pprof_addr")
+		v.notEmpty("admin.role_claim", adminConf.RoleClaim)
+	}
+
+	contest := c.ContestConfig
+	v.rfc3339("contest.starts_at", contest.StartsAt)
+	v.rfc3339("contest.ends_at", contest.EndsAt)
+	if startsAt, endsAt, err := contest.Schedule(); err == nil && !startsAt.IsZero() && !endsAt.IsZero() {
+		v.check(endsAt.After(startsAt), "contest.ends_at", contest.EndsAt, "must be after contest.starts_at")
+	}
+
+	v.check(c.TicketsConfig.Retention >= 0, "tickets.retention", c.TicketsConfig.Retention, "must not be negative")
+	if c.TicketsConfig.Retention > 0 {
+		v.positiveDuration("tickets.prune_interval", c.TicketsConfig.PruneInterval)
+	}
+
+	if c.LedgerConfig.Enabled {
+		v.positiveDuration("ledger.checkpoint_interval", c.LedgerConfig.CheckpointInterval)
+	}
+
+	voting := c.VotingConfig
+	switch voting.Mode {
+	case VotingSingle, VotingApproval, VotingRanked:
+	case VotingWeighted:
+		v.check(len(voting.Points) > 0, "voting.points", voting.Points, "must not be empty in weighted mode")
+		for _, p := range voting.Points {
+			v.check(p > 0, "voting.points", voting.Points, "must be positive")
+		}
+	default:
+		v.check(false, "voting.mode", voting.Mode, "must be single, approval, weighted or ranked")
+	}
+	v.check(voting.MinSelections >= 0, "voting.min_selections", voting.MinSelections, "must not be negative")
+	v.check(voting.MaxSelections >= 0, "voting.max_selections", voting.MaxSelections, "must not be negative")
+	if voting.MaxSelections > 0 {
+		v.check(voting.MinSelections <= voting.MaxSelections, "voting.min_selections", voting.MinSelections,
+			"must not exceed max_selections")
+	}
+	v.check(voting.Mode != VotingSingle || voting.MinSelections <= 1, "voting.min_selections", voting.MinSelections,
+		"must not exceed 1 in single mode")
+	v.check(voting.Mode != VotingWeighted || voting.MinSelections <= len(voting.Points), "voting.min_selections",
+		voting.MinSelections, "must not exceed the number of points in weighted mode")
+	for i, category := range voting.Categories {
+		field := fmt.Sprintf("voting.categories[%d]", i)
+		v.notEmpty(field+".name", category.Name)
+		v.check(len(category.Candidates) > 0, field+".candidates", category.Candidates, "must not be empty")
+		v.check(category.Min >= 1 && category.Min <= len(category.Candidates), field+".min", category.Min,
+			"must be between 1 and the number of candidates in the category")
+	}
+
+	if key := c.ResultsConfig.SigningKey; key != "" {
+		block, _ := pem.Decode([]byte(key.Reveal()))
+		v.check(block != nil && block.Type == "PRIVATE KEY", "results.signing_key", key,
+			"must be a PKCS#8 PEM private key, e.g. generated by openssl genpkey -algorithm ed25519")
+	}
+}
+
+// validate 校验 JWT 配置，section 为配置所在的段，例如 auth
+func (c JWTConf) validate(v *validator, section string) {
+	v.check(c.JWKSURL != "" || c.HMACSecret != "", section+".jwks_url", c.JWKSURL,
+		"jwks_url or hmac_secret is required when "+section+" is enabled")
+	if c.JWKSURL != "" {
+		u, err := url.Parse(c.JWKSURL)
+		v.check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", section+".jwks_url",
+			c.JWKSURL, "must be an http(s) url")
+		v.positiveDuration(section+".jwks_refresh", c.JWKSRefresh)
+	}
+	v.check(c.HMACSecret == "" || len(c.HMACSecret) >= minSecretLen, section+".hmac_secret",
+		c.HMACSecret, fmt.Sprintf("must be at least %d bytes", minSecretLen))
+	v.notEmpty(section+".subject_claim", c.SubjectClaim)
+}
+
+func (s *Settings) validate(v *validator) {
+	v.check(s.MaxVotes > 0, "maxVotes", s.MaxVotes, "must be greater than 0, otherwise no ticket can be used")
+	v.positiveDuration("ticketUpdateTime", s.TicketsUpdateTime)
+	v.positiveDuration("ticketCacheRefreshTime", s.TicketCacheRefreshTime)
+	v.positiveDuration("votesCacheToDbTime", s.VotesCacheToDbTime)
+	v.check(s.TicketLen >= minTicketLen && s.TicketLen <= maxTicketLen, "ticketLen", s.TicketLen,
+		fmt.Sprintf("must be between %d and %d", minTicketLen, maxTicketLen))
+	v.check(s.GoGC > 0 || s.GoGC == -1, "goGc", s.GoGC, "must be greater than 0, or -1 to disable gc")
+	v.positiveDuration("timeout.redis", s.RedisTimeout)
+	v.positiveDuration("timeout.mysql", s.MysqlTimeout)
+	v.positiveDuration("timeout.lockWait", s.LockWaitTimeout)
+	v.positiveDuration("timeout.syncVotes", s.SyncVotesTimeout)
+	s.RateLimit.validate(v)
+	v.check(s.ChallengeDifficulty >= minChallengeDifficulty && s.ChallengeDifficulty <= maxChallengeDifficulty,
+		"challenge.difficulty", s.ChallengeDifficulty,
+		fmt.Sprintf("must be between %d and %d", minChallengeDifficulty, maxChallengeDifficulty))
+	v.positiveDuration("challenge.ttl", s.ChallengeTTL)
+	v.check(s.ChallengeTicketUses > 0, "challenge.ticketUses", s.ChallengeTicketUses, "must be greater than 0")
+	v.check(s.MaxSyncLag > s.VotesCacheToDbTime, "health.maxSyncLag", s.MaxSyncLag,
+		"must be greater than votesCacheToDbTime, otherwise the instance is never ready")
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func validSettings() *Settings {
+	return &Settings{
+		MaxVotes:               100,
+		TicketsUpdateTime:      2 * time.Second,
+		TicketCacheRefreshTime: 2 * time.Second,
+		VotesCacheToDbTime:     2 * time.Second,
+		TicketLen:              10,
+		GoGC:                   100,
+		RedisTimeout:           time.Second,
+		MysqlTimeout:           time.Second,
+		LockWaitTimeout:        time.Second,
+		SyncVotesTimeout:       time.Second,
+		MaxSyncLag:             time.Minute,
+		ChallengeDifficulty:    16,
+		ChallengeTTL:           time.Minute,
+		ChallengeTicketUses:    1,
+	}
+}
+
+// 测试所有不合法的配置项会被一次性报告出来
+func TestValidateReportsEveryField(t *testing.T) {
+	assert.NoError(t, validSettings().Validate())
+
+	s := validSettings()
+	s.TicketLen = 1
+	s.TicketsUpdateTime = 0
+	s.MaxVotes = 0
+	err := s.Validate()
+
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	var fields []string
+	for _, fe := range verr.Errors {
+		fields = append(fields, fe.Field)
+	}
+	assert.Equal(t, []string{"maxVotes", "ticketUpdateTime", "ticketLen"}, fields)
+}
+
+func TestValidateGlobalConfig(t *testing.T) {
+	c := &GlobalConfig{
+		AppConfig:    AppConf{Port: 9090, Pprof: true, PprofAddr: ":9090", ShutdownTimeout: time.Second, StartupTimeout: time.Second},
+		DbConfig:     DbConf{Host: "127.0.0.1", Port: "3306", User: "root", Dbname: "voteme", MaxOpenConn: 10, MaxIdleConn: 20},
+		RedisConfig:  RedisConf{Host: "127.0.0.1", Port: 6379, PoolSile: 10, MinIdleConn: 1},
+		LogConfig:    LogConf{Level: "info", Format: "json"},
+		TraceConfig:  TraceConf{Exporter: "none", SampleRatio: 1},
+		AdminConfig:  AdminConf{Enabled: true, Addr: ":9090", JWTConf: JWTConf{SubjectClaim: "sub"}, RoleClaim: "role"},
+		VotingConfig: VotingConf{Mode: VotingApproval},
+	}
+	err := c.Validate()
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	var fields []string
+	for _, fe := range verr.Errors {
+		fields = append(fields, fe.Field)
+	}
+	// 管理接口没有配置密钥，并且与投票接口、pprof 共用了地址
+	assert.Equal(t, []string{"app.pprof_addr", "db.max_idle_conn", "admin.jwks_url", "admin.addr", "admin.addr"}, fields)
+}
@@ -0,0 +1,357 @@
+package contest
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/logging"
+	"VoteMe/model"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"time"
+)
+
+// State 投票活动的状态
+// scheduled → open → paused → closed → certified，paused 可以回到 open，open 和 paused 都可以直接结束
+type State string
+
+const (
+	Scheduled State = "scheduled" // 还没开始，到计划开始时间或管理员手动开放后进入 open
+	Open      State = "open"      // 开放投票
+	Paused    State = "paused"    // 暂停投票，可以恢复
+	Closed    State = "closed"    // 已结束，不再接受投票
+	Certified State = "certified" // 结果已确认，不可再变更
+)
+
+// States 所有状态，按状态机的顺序
+var States = []State{Scheduled, Open, Paused, Closed, Certified}
+
+// transitions 每个状态可以转到的状态
+var transitions = map[State][]State{
+	Scheduled: {Open},
+	Open:      {Paused, Closed},
+	Paused:    {Open, Closed},
+	Closed:    {Certified},
+}
+
+// BySchedule 按计划时间自动变更状态时记录的操作人
+const BySchedule = "schedule"
+
+var (
+	// ErrNotOpen 投票活动不在开放状态
+	ErrNotOpen = errors.New("voting is not open")
+	// ErrInvalidTransition 当前状态不能转到目标状态
+	ErrInvalidTransition = errors.New("invalid contest state transition")
+	// ErrInvalidSchedule 计划时间不合法
+	ErrInvalidSchedule = errors.New("invalid contest schedule")
+	// ErrConflict 多个实例同时修改状态，重试后仍然冲突
+	ErrConflict = errors.New("contest was modified concurrently")
+	// ErrNotInitialized mysql 中没有活动状态，此时拒绝投票而不是按默认状态开放
+	ErrNotInitialized = errors.New("contest state is not initialized")
+)
+
+// Contest 投票活动，保存在 mysql 中，redis 中的 hash 作为缓存，所有实例共享
+type Contest struct {
+	State     State
+	Reason    string    // 最近一次变更的原因
+	StartsAt  time.Time // 计划开始时间，零值表示需要手动开放
+	EndsAt    time.Time // 计划结束时间，零值表示需要手动结束
+	UpdatedAt time.Time
+	UpdatedBy string // 最近一次变更的操作人，按计划时间变更时为 schedule
+	version   int64  // 乐观锁版本号，每次写入加一
+}
+
+// Effective 按计划时间推算 now 时刻的状态，计划时间到了但还没有持久化时也能立即生效
+func (c Contest) Effective(now time.Time) Contest {
+	if c.State == Scheduled && !c.StartsAt.IsZero() && !now.Before(c.StartsAt) {
+		c.State, c.Reason, c.UpdatedAt, c.UpdatedBy = Open, "scheduled start", c.StartsAt, BySchedule
+	}
+	if (c.State == Open || c.State == Paused) && !c.EndsAt.IsZero() && !now.Before(c.EndsAt) {
+		c.State, c.Reason, c.UpdatedAt, c.UpdatedBy = Closed, "scheduled end", c.EndsAt, BySchedule
+	}
+	return c
+}
+
+// CanTransition 是否可以从 from 转到 to
+func CanTransition(from, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheTTL redis 中活动状态缓存的有效期，过期后从 mysql 重新加载，缓存没有及时更新时最多过期这么久
+const cacheTTL = time.Minute
+
+// Migrate 创建活动状态表
+func Migrate(ctx context.Context) error {
+	return db.GetDB().WithContext(ctx).AutoMigrate(&model.Contest{})
+}
+
+// Init 按配置初始化活动状态，mysql 中已有活动状态时不会覆盖，多个实例同时启动也只会写入一次
+// 之前只保存在 redis 中的活动状态会原样写入 mysql；没有配置开始时间时立即开放，与之前不区分活动状态的行为一致
+func Init(ctx context.Context, conf config.ContestConf) error {
+	startsAt, endsAt, err := conf.Schedule()
+	if err != nil {
+		return err
+	}
+	c := Contest{State: Open, StartsAt: startsAt, EndsAt: endsAt, UpdatedAt: time.Now(), UpdatedBy: "config"}
+	if !startsAt.IsZero() {
+		c.State = Scheduled
+	}
+	if cached, ok, err := readCache(ctx); err != nil {
+		return err
+	} else if ok {
+		c = cached
+	}
+	row := c.row()
+	if err := db.GetDB().WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+		return fmt.Errorf("save contest state: %w", err)
+	}
+	stored, err := loadStored(ctx)
+	if err != nil {
+		return err
+	}
+	// 之前的缓存没有过期时间，按 mysql 中的状态重新写入
+	if err := dropCache(ctx); err != nil {
+		return err
+	}
+	return writeCache(ctx, stored)
+}
+
+// Get 返回当前的活动状态，计划时间已到的变更立即生效
+func Get(ctx context.Context) (Contest, error) {
+	c, err := load(ctx)
+	if err != nil {
+		return Contest{}, err
+	}
+	return c.Effective(time.Now()), nil
+}
+
+// RequireOpen 活动不在开放状态时返回 ErrNotOpen
+func RequireOpen(ctx context.Context) (Contest, error) {
+	c, err := Get(ctx)
+	if err != nil {
+		return c, err
+	}
+	if c.State != Open {
+		return c, fmt.Errorf("%w: contest is %s", ErrNotOpen, c.State)
+	}
+	return c, nil
+}
+
+// Transition 把活动转到 to 状态
+func Transition(ctx context.Context, to State, reason, actor string) (Contest, error) {
+	return modify(ctx, actor, func(c *Contest) error {
+		if !CanTransition(c.State, to) {
+			return fmt.Errorf("%w: cannot move contest from %s to %s", ErrInvalidTransition, c.State, to)
+		}
+		c.State, c.Reason = to, reason
+		return nil
+	})
+}
+
+// SetSchedule 修改计划开始和结束时间，零值表示取消计划时间；活动结束后不能再修改
+func SetSchedule(ctx context.Context, startsAt, endsAt time.Time, actor string) (Contest, error) {
+	return modify(ctx, actor, func(c *Contest) error {
+		if c.State == Closed || c.State == Certified {
+			return fmt.Errorf("%w: contest is already %s", ErrInvalidSchedule, c.State)
+		}
+		if !endsAt.IsZero() && !endsAt.After(time.Now()) {
+			return fmt.Errorf("%w: ends_at must be in the future", ErrInvalidSchedule)
+		}
+		if !startsAt.IsZero() && !endsAt.IsZero() && !endsAt.After(startsAt) {
+			return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidSchedule)
+		}
+		c.StartsAt, c.EndsAt, c.Reason = startsAt, endsAt, "schedule changed"
+		return nil
+	})
+}
+
+// modify 从 mysql 读取当前状态，按计划时间推算后交给 fn 修改，再按版本号写回，版本冲突时重试
+func modify(ctx context.Context, actor string, fn func(c *Contest) error) (Contest, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		stored, err := loadStored(ctx)
+		if err != nil {
+			return Contest{}, err
+		}
+		c := stored.Effective(time.Now())
+		if err := fn(&c); err != nil {
+			return c, err
+		}
+		c.UpdatedAt, c.UpdatedBy = time.Now(), actor
+		ok, err := save(ctx, stored.version, c)
+		if err != nil {
+			return Contest{}, err
+		}
+		if ok {
+			return c, nil
+		}
+	}
+	return Contest{}, ErrConflict
+}
+
+// cacheScript 缓存中的版本号比要写入的旧时才写入，避免多个实例乱序写入时旧状态覆盖新状态
+// ARGV[1] 为版本号，ARGV[2] 为过期时间（毫秒），之后是字段和值
+var cacheScript = redis.NewScript(`
+local cached = redis.call('HGET', KEYS[1], 'version')
+if cached and tonumber(cached) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'version', ARGV[1], unpack(ARGV, 3))
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+// load 读取活动状态，优先使用 redis 中的缓存
+func load(ctx context.Context) (Contest, error) {
+	c, ok, err := readCache(ctx)
+	if err != nil || ok {
+		return c, err
+	}
+	// 缓存过期或 redis 被清空后从 mysql 重新加载，mysql 中也没有时返回错误
+	if c, err = loadStored(ctx); err != nil {
+		return Contest{}, err
+	}
+	return c, writeCache(ctx, c)
+}
+
+// loadStored 从 mysql 读取活动状态
+func loadStored(ctx context.Context) (Contest, error) {
+	var row model.Contest
+	err := db.GetDB().WithContext(ctx).Where("name = ?", keys.Default().Prefix()).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Contest{}, ErrNotInitialized
+	} else if err != nil {
+		return Contest{}, fmt.Errorf("load contest state: %w", err)
+	}
+	return Contest{
+		State:     State(row.State),
+		Reason:    row.Reason,
+		StartsAt:  fromPtr(row.StartsAt),
+		EndsAt:    fromPtr(row.EndsAt),
+		UpdatedAt: row.UpdatedAt,
+		UpdatedBy: row.UpdatedBy,
+		version:   row.Version,
+	}, nil
+}
+
+// save 版本号与读取时一致才写入 mysql 并把版本号加一，写入后更新缓存
+func save(ctx context.Context, version int64, c Contest) (bool, error) {
+	row := c.row()
+	res := db.GetDB().WithContext(ctx).Model(&model.Contest{}).
+		Where("name = ? AND version = ?", row.Name, version).
+		Updates(map[string]interface{}{
+			"state":      row.State,
+			"reason":     row.Reason,
+			"starts_at":  row.StartsAt,
+			"ends_at":    row.EndsAt,
+			"updated_at": row.UpdatedAt,
+			"updated_by": row.UpdatedBy,
+			"version":    gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return false, fmt.Errorf("save contest state: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+	c.version = version + 1
+	if err := writeCache(ctx, c); err != nil {
+		// 状态已经持久化，缓存最多 cacheTTL 后从 mysql 重新加载
+		logging.FromContext(ctx).WithError(err).Warn("update contest state cache failed")
+	}
+	return true, nil
+}
+
+func readCache(ctx context.Context) (Contest, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	fields, err := db.GetRedisCLi().HGetAll(ctx, keys.Contest()).Result()
+	if err != nil || len(fields) == 0 {
+		return Contest{}, false, err
+	}
+	version, _ := strconv.ParseInt(fields["version"], 10, 64)
+	return Contest{
+		State:     State(fields["state"]),
+		Reason:    fields["reason"],
+		StartsAt:  parseTime(fields["starts_at"]),
+		EndsAt:    parseTime(fields["ends_at"]),
+		UpdatedAt: parseTime(fields["updated_at"]),
+		UpdatedBy: fields["updated_by"],
+		version:   version,
+	}, true, nil
+}
+
+func writeCache(ctx context.Context, c Contest) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	args := append([]interface{}{c.version, cacheTTL.Milliseconds()}, c.fields()...)
+	return cacheScript.Run(ctx, db.GetRedisCLi(), []string{keys.Contest()}, args...).Err()
+}
+
+func dropCache(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	return db.GetRedisCLi().Del(ctx, keys.Contest()).Err()
+}
+
+// row 转换为 mysql 中的记录
+func (c Contest) row() model.Contest {
+	return model.Contest{
+		Name:      keys.Default().Prefix(),
+		State:     string(c.State),
+		Reason:    c.Reason,
+		StartsAt:  toPtr(c.StartsAt),
+		EndsAt:    toPtr(c.EndsAt),
+		UpdatedAt: c.UpdatedAt,
+		UpdatedBy: c.UpdatedBy,
+		Version:   c.version,
+	}
+}
+
+// fields 转换为 HSET 的字段和值，不包含版本号
+func (c Contest) fields() []interface{} {
+	return []interface{}{
+		"state", string(c.State),
+		"reason", c.Reason,
+		"starts_at", formatTime(c.StartsAt),
+		"ends_at", formatTime(c.EndsAt),
+		"updated_at", formatTime(c.UpdatedAt),
+		"updated_by", c.UpdatedBy,
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+func toPtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func fromPtr(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
@@ -0,0 +1,35 @@
+package contest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEffective(t *testing.T) {
+	start := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	c := Contest{State: Scheduled, StartsAt: start, EndsAt: end}
+
+	assert.Equal(t, Scheduled, c.Effective(start.Add(-time.Second)).State)
+	assert.Equal(t, Open, c.Effective(start).State, "到计划开始时间立即开放")
+	assert.Equal(t, Closed, c.Effective(end).State, "错过了开始时间也会按结束时间关闭")
+
+	paused := Contest{State: Paused, EndsAt: end}
+	assert.Equal(t, Paused, paused.Effective(start).State)
+	assert.Equal(t, Closed, paused.Effective(end.Add(time.Minute)).State)
+	assert.Equal(t, BySchedule, paused.Effective(end).UpdatedBy)
+
+	manual := Contest{State: Scheduled}
+	assert.Equal(t, Scheduled, manual.Effective(end).State, "没有计划时间时需要手动开放")
+}
+
+func TestCanTransition(t *testing.T) {
+	assert.True(t, CanTransition(Scheduled, Open))
+	assert.True(t, CanTransition(Paused, Open))
+	assert.True(t, CanTransition(Paused, Closed))
+	assert.True(t, CanTransition(Closed, Certified))
+	assert.False(t, CanTransition(Scheduled, Paused))
+	assert.False(t, CanTransition(Closed, Open), "结束后不能重新开放")
+	assert.False(t, CanTransition(Certified, Closed))
+}
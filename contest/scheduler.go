@@ -0,0 +1,56 @@
+package contest
+
+import (
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// checkInterval 检查计划时间的间隔，投票和获取票据时总是按当前时间推算状态，这里只负责持久化和记录
+const checkInterval = time.Second
+
+// Scheduler 按计划时间把状态变更写入 mysql 和缓存，并更新状态指标
+// 出错时返回错误由调用方决定是否重启，ctx 取消后正常退出
+func Scheduler(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if err := persistSchedule(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// persistSchedule 计划时间已到但保存的还是旧状态时写入新状态
+func persistSchedule(ctx context.Context) error {
+	stored, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	c := stored.Effective(time.Now())
+	if c.State != stored.State {
+		// 多个实例同时写入时只有一个成功，其他实例因版本冲突跳过
+		ok, err := save(ctx, stored.version, c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			logging.FromContext(ctx).WithFields(log.Fields{"from": stored.State, "to": c.State}).Info("contest state changed by schedule")
+		}
+	}
+	for _, s := range States {
+		v := 0.0
+		if s == c.State {
+			v = 1
+		}
+		metrics.ContestState.WithLabelValues(string(s)).Set(v)
+	}
+	return nil
+}
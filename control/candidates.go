@@ -0,0 +1,30 @@
+package control
+
+import "sync/atomic"
+
+// candidates 候选人名单，由 LoadCandidates 在启动时从数据库加载
+var candidates atomic.Pointer[map[string]bool]
+
+// SetCandidates 设置候选人名单
+func SetCandidates(names []string) {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	candidates.Store(&set)
+}
+
+// CheckCandidates 检查选手是否都在候选人名单中，不在时返回 *UnknownCandidateError
+// 名单还没有加载时不做检查
+func CheckCandidates(names []string) error {
+	set := candidates.Load()
+	if set == nil {
+		return nil
+	}
+	for _, name := range names {
+		if !(*set)[name] {
+			return &UnknownCandidateError{Name: name}
+		}
+	}
+	return nil
+}
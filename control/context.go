@@ -0,0 +1,29 @@
+package control
+
+import (
+	"VoteMe/config"
+	"context"
+	"time"
+)
+
+// withRedisTimeout 为单次 redis 操作设置超时时间，调用方的 ctx 先取消时以调用方为准
+func withRedisTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, config.Current().RedisTimeout)
+}
+
+// withMysqlTimeout 为单次 mysql 操作设置超时时间
+func withMysqlTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, config.Current().MysqlTimeout)
+}
+
+// sleep 等待一段时间，ctx 取消时提前返回 ctx 的错误，用于重试循环
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
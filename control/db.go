@@ -4,6 +4,7 @@ import (
 	"VoteMe/config"
 	"VoteMe/db"
 	"VoteMe/model"
+	"context"
 	"fmt"
 	"math/rand"
 	"time"
@@ -24,10 +25,12 @@ import (
 //	}
 
 // UpdateUserVotes 5 ms，理论上来说，这种方式直接淘汰。
-func UpdateUserVotes(userName string) error {
+func UpdateUserVotes(ctx context.Context, userName string) error {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 	// 构建并执行一个SQL更新语句来直接增加用户的票数
 	// 这里假设用户表名为`users`，并且有`name`和`votes`列
-	result := db.GetDB().Exec("UPDATE users SET votes = votes + 1 WHERE name = ?", userName)
+	result := db.GetDB().WithContext(ctx).Exec("UPDATE users SET votes = votes + 1 WHERE name = ?", userName)
 
 	if result.Error != nil {
 		return result.Error // 如果执行SQL语句出错，返回错误
@@ -42,11 +45,11 @@ func UpdateUserVotes(userName string) error {
 }
 
 // UpdateUserVotesWithRetry 重试间隔和次数
-func UpdateUserVotesWithRetry(userName string) error {
+func UpdateUserVotesWithRetry(ctx context.Context, userName string) error {
 	var err error
 	maxRetries := 10
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		err = UpdateUserVotesDirectSQL(userName)
+		err = UpdateUserVotesDirectSQL(ctx, userName)
 		if err == nil {
 			return nil // 成功，返回nil
 		}
@@ -54,7 +57,9 @@ func UpdateUserVotesWithRetry(userName string) error {
 		// 例如，如果是因为版本冲突导致的更新失败，可能会希望重试
 		// 如果是其他类型的错误，可能就不重试
 		// 为了简化示例，这里假设所有错误都重试
-		time.Sleep(time.Duration(rand.Intn(50)+10) * time.Millisecond)
+		if sleepErr := sleep(ctx, time.Duration(rand.Intn(50)+10)*time.Millisecond); sleepErr != nil {
+			return fmt.Errorf("update user votes canceled after %d attempts: %w", attempt+1, sleepErr)
+		}
 	}
 	return fmt.Errorf("failed to update user votes after %d attempts: %v", maxRetries, err)
 }
@@ -82,16 +87,18 @@ func UpdateUserVotesWithRetry(userName string) error {
 //}
 
 // UpdateUserVotesMutex 6ms，淘汰
-func UpdateUserVotesMutex(userName string) error {
+func UpdateUserVotesMutex(ctx context.Context, userName string) error {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 
 	var user model.User
-	result := db.GetDB().Where("name = ?", userName).First(&user)
+	result := db.GetDB().WithContext(ctx).Where("name = ?", userName).First(&user)
 	if result.Error != nil {
 		return result.Error
 	}
 
 	// 尝试更新记录，同时增加版本号
-	result = db.GetDB().Model(&user).Where("version = ?", user.Version).Updates(model.User{
+	result = db.GetDB().WithContext(ctx).Model(&user).Where("version = ?", user.Version).Updates(model.User{
 		Votes:   user.Votes + 1,
 		Version: user.Version + 1,
 	})
@@ -105,15 +112,17 @@ func UpdateUserVotesMutex(userName string) error {
 	// 等待一定时间后重试
 
 	// 所有尝试都失败
-	return fmt.Errorf("failed to update user votes after %d attempts due to version conflict")
+	return fmt.Errorf("failed to update user votes due to version conflict")
 }
 
-func UpdateUserVotesDirectSQL(userName string) error {
+func UpdateUserVotesDirectSQL(ctx context.Context, userName string) error {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 	// SQL更新语句，同时增加votes和version字段
 	sql := `UPDATE users SET votes = votes + 1, version = version + 1 WHERE name = ? AND version = (SELECT version FROM (SELECT version FROM users WHERE name = ?) AS v)`
 
 	// 执行SQL语句
-	result := db.GetDB().Exec(sql, userName, userName)
+	result := db.GetDB().WithContext(ctx).Exec(sql, userName, userName)
 
 	if result.Error != nil {
 		return result.Error // 如果执行SQL语句出错，返回错误
@@ -129,10 +138,12 @@ func UpdateUserVotesDirectSQL(userName string) error {
 
 // GetUserVotes 获取用户票数
 // 这个函数接受一个用户名作为参数，返回该用户的当前票数
-func GetUserVotes(userName string) (int, error) {
+func GetUserVotes(ctx context.Context, userName string) (int, error) {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 	var votes int
 	// 直接使用SQL查询语句
-	result := db.GetDB().Raw("SELECT votes FROM users WHERE name = ? LIMIT 1", userName).Scan(&votes)
+	result := db.GetDB().WithContext(ctx).Raw("SELECT votes FROM users WHERE name = ? LIMIT 1", userName).Scan(&votes)
 	if result.Error != nil {
 		return 0, result.Error // 如果执行SQL语句出错，返回错误
 	}
@@ -143,12 +154,14 @@ func GetUserVotes(userName string) (int, error) {
 }
 
 // UpdateTicket 判断是否超时以及次数是否达到上限
-func UpdateTicket(ticketID string) (*model.Ticket, error) {
+func UpdateTicket(ctx context.Context, ticketID string) (*model.Ticket, error) {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 	var ticket model.Ticket
 
 	// 执行条件更新
-	result := db.GetDB().Exec("UPDATE tickets SET uses = uses + 1 WHERE ticket_id = ? AND uses < ?",
-		ticketID, config.MaxVotes)
+	result := db.GetDB().WithContext(ctx).Exec("UPDATE tickets SET uses = uses + 1 WHERE ticket_id = ? AND uses < ?",
+		ticketID, config.Current().MaxVotes)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -177,9 +190,11 @@ func UpdateTicket(ticketID string) (*model.Ticket, error) {
 //	return &ticket, err
 //}
 
-func CreateOrTicket(ticketID string) error {
+func CreateOrTicket(ctx context.Context, ticketID string) error {
+	ctx, cancel := withMysqlTimeout(ctx)
+	defer cancel()
 	var ticket model.Ticket
-	err := db.GetDB().Where("ticket_id = ?", ticketID).FirstOrCreate(&ticket, model.Ticket{TicketID: ticketID}).Error
+	err := db.GetDB().WithContext(ctx).Where("ticket_id = ?", ticketID).FirstOrCreate(&ticket, model.Ticket{TicketID: ticketID}).Error
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,40 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 投票相关的错误，解析函数按类型转换为 GraphQL 错误码，客户端不需要匹配错误信息
+var (
+	// ErrTicketExpired 票据已过期
+	ErrTicketExpired = errors.New("ticket has expired")
+	// ErrTicketUnknown 票据从未签发过，或者签发记录已超过保留时间
+	ErrTicketUnknown = errors.New("unknown ticket")
+	// ErrTicketExhausted 票据的使用次数已达上限
+	ErrTicketExhausted = errors.New("ticket has reached its maximum usage")
+	// ErrVoterExhausted 投票人使用这张票据的次数已达上限，errors.Is(err, ErrTicketExhausted) 为 true
+	ErrVoterExhausted = fmt.Errorf("%w for this voter", ErrTicketExhausted)
+	// ErrUnknownCandidate 选手不在候选人名单中
+	ErrUnknownCandidate = errors.New("unknown candidate")
+	// ErrBackendUnavailable redis、mysql 等依赖出错，可以稍后重试
+	ErrBackendUnavailable = errors.New("backend unavailable")
+)
+
+// UnknownCandidateError 不在候选人名单中的选手，errors.Is(err, ErrUnknownCandidate) 为 true
+type UnknownCandidateError struct {
+	Name string
+}
+
+func (e *UnknownCandidateError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrUnknownCandidate, e.Name)
+}
+
+func (e *UnknownCandidateError) Unwrap() error {
+	return ErrUnknownCandidate
+}
+
+// unavailable 把依赖返回的错误包装为 ErrBackendUnavailable，保留原始错误用于日志
+func unavailable(op string, err error) error {
+	return fmt.Errorf("%w: %s: %v", ErrBackendUnavailable, op, err)
+}
@@ -3,34 +3,33 @@ package control
 import (
 	"VoteMe/config"
 	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/logging"
+	"VoteMe/metrics"
 	"context"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"log"
 	"math/rand"
 	"strconv"
 	"time"
 )
 
-var ctx = context.Background()
-
 // UpdateUserVotesWithLock redis 分布式锁进行投票
-func UpdateUserVotesWithLock(userName string) error {
-	lockKey := "Voteme:update:user:vote:lock:" + userName
+func UpdateUserVotesWithLock(ctx context.Context, userName string) error {
+	lockKey := keys.VoteLock(userName)
 	lockVal := "1" // 用于标识锁的持有者，可以是一个更复杂的标识，如UUID
 
-	startTime := time.Now()
-	maxTime := 10 * time.Second
-	// 循环直到获取锁或超过最大等待时间
+	maxTime := config.Current().LockWaitTimeout
+	ctx, cancel := context.WithTimeout(ctx, maxTime)
+	defer cancel()
+	// 循环直到获取锁、超过最大等待时间或者调用方取消
 	for {
-		if time.Since(startTime) > maxTime {
-			return fmt.Errorf("failed to acquire lock for user %s within %d seconds", userName, maxTime)
-		}
-
 		// 尝试获取锁
-		locked, err := db.GetRedisCLi().SetNX(ctx, lockKey, lockVal, 10*time.Millisecond).Result()
+		lockCtx, lockCancel := withRedisTimeout(ctx)
+		locked, err := db.GetRedisCLi().SetNX(lockCtx, lockKey, lockVal, 10*time.Millisecond).Result()
+		lockCancel()
 		if err != nil {
-			return fmt.Errorf("error while attempting to lock for user %s: %v", userName, err)
+			return fmt.Errorf("error while attempting to lock for user %s: %w", userName, err)
 		}
 		if locked {
 			// 成功获取锁，使用defer语句确保最后释放锁
@@ -42,81 +41,200 @@ func UpdateUserVotesWithLock(userName string) error {
                 else
                     return 0
                 end`
-				_, err := db.GetRedisCLi().Eval(ctx, script, []string{lockKey}, lockVal).Result()
+				// 即使调用方已经取消也要释放锁
+				releaseCtx, releaseCancel := withRedisTimeout(context.WithoutCancel(ctx))
+				defer releaseCancel()
+				_, err := db.GetRedisCLi().Eval(releaseCtx, script, []string{lockKey}, lockVal).Result()
 				if err != nil {
-					log.Printf("failed to release lock for user %s: %v\n", userName, err)
+					logging.FromContext(ctx).WithError(err).Errorf("failed to release lock for user %s", userName)
 				}
 			}()
 
-			return UpdateUserVotes(userName) // 调用原有逻辑更新票数
+			return UpdateUserVotes(ctx, userName) // 调用原有逻辑更新票数
 		}
 
 		// 使用一个更大的随机间隔来减少锁竞争
-		time.Sleep(time.Duration(rand.Intn(100)+10) * time.Millisecond) // 随机等待时间在100到600毫秒之间
-
+		if err := sleep(ctx, time.Duration(rand.Intn(100)+10)*time.Millisecond); err != nil {
+			return fmt.Errorf("failed to acquire lock for user %s within %s: %w", userName, maxTime, err)
+		}
 	}
 }
 
-// SetValidateTicket 将有效票据缓存起来，设置过期时间以及使用次数
-func SetValidateTicket(ticketID string, maxVotes int, ticketUpdateTime time.Duration) error {
+// issuedTicketTTL 签发记录的保留时间，超过后过期的票据按从未签发处理
+const issuedTicketTTL = 24 * time.Hour
+
+// SetValidateTicket 将有效票据缓存起来，设置过期时间以及使用次数，同时记录签发过这张票据
+// 签发记录的值为已使用的次数，票据过期后由 TicketUses 读取
+func SetValidateTicket(ctx context.Context, ticketID string, maxVotes int, ticketUpdateTime time.Duration) error {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
 	//maxVotesStr := fmt.Sprint(maxVotes)
-	ticketIDCache := fmt.Sprintf("Voteme:ticketIDCache:%s", ticketID)
-	err := db.GetRedisCLi().Set(context.Background(), ticketIDCache, maxVotes, ticketUpdateTime).Err()
+	ticketIDCache := keys.Ticket(ticketID)
+	issuedTTL := issuedTicketTTL
+	if ticketUpdateTime > issuedTTL {
+		issuedTTL = ticketUpdateTime
+	}
+	_, err := db.GetRedisCLi().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, keys.IssuedTicket(ticketID), 0, issuedTTL)
+		pipe.Set(ctx, ticketIDCache, maxVotes, ticketUpdateTime)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// 票据校验脚本的返回值，大于等于 0 时为剩余使用次数
+const (
+	ticketExhausted = -1
+	ticketExpired   = -2
+	ticketUnknown   = -3
+	voterExhausted  = -4
+)
+
+// decreaseTicketScript 原子地检查票据并扣减一次使用次数，同时在签发记录上累加已使用的次数
+// 票据不存在时不会创建键，使用次数用完后也不再扣减
+// KEYS[1] 票据，KEYS[2] 签发记录，KEYS[3] 投票人的使用次数（可选）；ARGV[1] 每个投票人的最大使用次数
+var decreaseTicketScript = redis.NewScript(`
+local remaining = redis.call("GET", KEYS[1])
+if not remaining then
+	if redis.call("EXISTS", KEYS[2]) == 1 then
+		return -2
+	end
+	return -3
+end
+if tonumber(remaining) <= 0 then
+	return -1
+end
+if KEYS[3] then
+	local used = tonumber(redis.call("GET", KEYS[3]) or "0")
+	if used >= tonumber(ARGV[1]) then
+		return -4
+	end
+	redis.call("INCR", KEYS[3])
+	local ttl = redis.call("PTTL", KEYS[1])
+	if used == 0 and ttl > 0 then
+		redis.call("PEXPIRE", KEYS[3], ttl)
+	end
+end
+redis.call("INCR", KEYS[2])
+return redis.call("DECR", KEYS[1])
+`)
+
 // DecreaseUsageLimit 减少键的使用次数，并检查是否达到上限或过期
-func DecreaseUsageLimit(ticketID string) error {
-	ticketIDCache := fmt.Sprintf("Voteme:ticketIDCache:%s", ticketID)
+// 票据已过期时返回 ErrTicketExpired，从未签发过时返回 ErrTicketUnknown，使用次数用完时返回 ErrTicketExhausted，
+// redis 出错时返回 ErrBackendUnavailable
+func DecreaseUsageLimit(ctx context.Context, ticketID string) error {
+	return decreaseUsage(ctx, []string{keys.Ticket(ticketID), keys.IssuedTicket(ticketID)})
+}
+
+// DecreaseVoterUsage 与 DecreaseUsageLimit 相同，同时限制每个投票人使用这张票据的次数，
+// 投票人用完 limit 次后返回 ErrVoterExhausted，不影响其他投票人
+func DecreaseVoterUsage(ctx context.Context, ticketID, voter string, limit int) error {
+	return decreaseUsage(ctx, []string{keys.Ticket(ticketID), keys.IssuedTicket(ticketID), keys.VoterTicket(ticketID, voter)}, limit)
+}
 
-	// 使用DECR命令减少票据的可用次数
-	result, err := db.GetRedisCLi().Decr(context.Background(), ticketIDCache).Result()
+func decreaseUsage(ctx context.Context, ticketKeys []string, args ...interface{}) error {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+
+	result, err := decreaseTicketScript.Run(ctx, db.GetRedisCLi(), ticketKeys, args...).Int64()
 	if err != nil {
-		return err // 处理可能的Redis错误
+		return unavailable("decrease ticket usage", err)
 	}
-
-	if result < 0 {
+	switch result {
+	case ticketExpired:
+		metrics.TicketChecks.WithLabelValues("expired").Inc()
+		return ErrTicketExpired
+	case ticketUnknown:
+		metrics.TicketChecks.WithLabelValues("unknown").Inc()
+		return ErrTicketUnknown
+	case ticketExhausted:
 		// 票据使用次数已超上限
-		return fmt.Errorf("ticket %s has reached its maximum usage", ticketID)
+		metrics.TicketChecks.WithLabelValues("exhausted").Inc()
+		return ErrTicketExhausted
+	case voterExhausted:
+		metrics.TicketChecks.WithLabelValues("voter_exhausted").Inc()
+		return ErrVoterExhausted
 	}
 
 	// 票据有效
+	metrics.TicketChecks.WithLabelValues("ok").Inc()
+	metrics.TicketRemainingUses.Set(float64(result))
 	return nil
 }
 
+// TicketUses 返回票据已使用的次数，签发记录超过保留时间后返回 ErrTicketUnknown
+func TicketUses(ctx context.Context, ticketID string) (int, error) {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	uses, err := db.GetRedisCLi().Get(ctx, keys.IssuedTicket(ticketID)).Int()
+	if err == redis.Nil {
+		return 0, ErrTicketUnknown
+	} else if err != nil {
+		return 0, unavailable("get ticket uses", err)
+	}
+	return uses, nil
+}
+
+// getCachedVotes 读取缓存中的票数，单次读取受 redis 超时时间限制
+func getCachedVotes(ctx context.Context, key string) (string, error) {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	return db.GetRedisCLi().Get(ctx, key).Result()
+}
+
 // GetVotesByName 获取某个选手的票数：这里是缓存，会有一定时延,导致数据不准确
-func GetVotesByName(name string) (int, error) {
-	key := fmt.Sprintf("Voteme:current:votes:%s", name)
-	votesStr, err := db.GetRedisCLi().Get(context.Background(), key).Result()
+func GetVotesByName(ctx context.Context, name string) (int, error) {
+	key := keys.CurrentVotes(name)
+	votesStr, err := getCachedVotes(ctx, key)
+	if err == nil {
+		metrics.CacheRequests.WithLabelValues("user_votes", "hit").Inc()
+	}
 	if err == redis.Nil {
-		lockKey := "Voteme:get:user:vote:lock:" + name // 使用不同的键作为锁
+		metrics.CacheRequests.WithLabelValues("user_votes", "miss").Inc()
+		lockKey := keys.CurrentVotesLock(name) // 使用不同的键作为锁
 		lockValue := "1"
 		// 尝试获取锁
-		ok, err := db.GetRedisCLi().SetNX(context.Background(), lockKey, lockValue, 20*time.Millisecond).Result()
+		lockCtx, lockCancel := withRedisTimeout(ctx)
+		ok, err := db.GetRedisCLi().SetNX(lockCtx, lockKey, lockValue, 20*time.Millisecond).Result()
+		lockCancel()
 		if err != nil {
 			return 0, err
 		}
 
 		if ok {
-			defer db.GetRedisCLi().Del(context.Background(), lockKey) // 确保释放锁
-			votes, err := GetUserVotes(name)
+			defer func() { // 确保释放锁
+				releaseCtx, releaseCancel := withRedisTimeout(context.WithoutCancel(ctx))
+				defer releaseCancel()
+				if err := db.GetRedisCLi().Del(releaseCtx, lockKey).Err(); err != nil {
+					logging.FromContext(ctx).WithError(err).Warnf("failed to release cache lock for user %s", name)
+				}
+			}()
+			votes, err := GetUserVotes(ctx, name)
 			if err != nil {
 				return 0, err
 			}
 			//fmt.Println("hit mysql---------")
-			db.GetRedisCLi().Set(context.Background(), key, votes, config.TicketCacheRefreshTime)
+			setCtx, setCancel := withRedisTimeout(ctx)
+			defer setCancel()
+			err = db.GetRedisCLi().Set(setCtx, key, votes, config.Current().TicketCacheRefreshTime).Err()
+			if err != nil {
+				// 缓存写入失败不影响本次结果，下次请求会再回源
+				logging.FromContext(ctx).WithError(err).Warnf("failed to cache votes for user %s", name)
+			}
 			return votes, nil
 		}
 
 		// 如果没有获取到锁，则等待一段时间后重试
 		for i := 0; i < 3; i++ { // 重试次数
-			time.Sleep(10 * time.Millisecond)                                         // 等待时间
-			votesStr, err = db.GetRedisCLi().Get(context.Background(), name).Result() // 尝试再次从缓存获取
+			if err = sleep(ctx, 10*time.Millisecond); err != nil { // 等待时间
+				return 0, err
+			}
+			votesStr, err = getCachedVotes(ctx, key) // 尝试再次从缓存获取
 			if err == nil {
-				GetVotesByName(name) // 递归获取或者直接break
+				break
 			}
 		}
 	}
@@ -133,13 +251,37 @@ func GetVotesByName(name string) (int, error) {
 	return votesInt, nil
 }
 
-func VoteForUserRedis(userName string) error {
+func VoteForUserRedis(ctx context.Context, userName string) error {
+	return AddVotesRedis(ctx, userName, 1)
+}
+
+// AddVotesRedis 给选手增加 n 票，加权投票时 n 为该位置的分数
+func AddVotesRedis(ctx context.Context, userName string, n int64) error {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
 	// 投票计数器的键
-	key := fmt.Sprintf("Voteme:votes:%s", userName)
+	key := keys.Votes(userName)
 	// 增加用户的票数
-	_, err := db.GetRedisCLi().Incr(ctx, key).Result()
+	_, err := db.GetRedisCLi().IncrBy(ctx, key, n).Result()
 	if err != nil {
-		return err
+		return unavailable("add votes", err)
+	}
+	return nil
+}
+
+// AddBallotRedis 在一个事务中给选票上的所有选手加票，选票要么全部计入，要么全部不计入
+// votes 为选手名到票数，加权投票时为该位置的分数
+func AddBallotRedis(ctx context.Context, votes map[string]int64) error {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	_, err := db.GetRedisCLi().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for name, n := range votes {
+			pipe.IncrBy(ctx, keys.Votes(name), n)
+		}
+		return nil
+	})
+	if err != nil {
+		return unavailable("add ballot", err)
 	}
 	return nil
 }
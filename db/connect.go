@@ -0,0 +1,112 @@
+package db
+
+import (
+	"VoteMe/config"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	minConnectDelay = 200 * time.Millisecond // 第一次重连前的等待时间
+	maxConnectDelay = 5 * time.Second        // 重连的最大等待时间
+)
+
+// ConnectError 在超时时间内没能连上存储，Err 为最后一次失败的原因
+type ConnectError struct {
+	Backend  string // mysql 或 redis
+	Attempts int    // 尝试次数
+	Err      error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connect %s failed after %d attempt(s): %v", e.Backend, e.Attempts, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// Connect 同时连接 mysql 和 redis，失败时按指数退避重试，直到 ctx 取消或超时
+// 返回的错误可以通过 errors.As 取到 *ConnectError，两个都失败时两个错误都会返回
+func Connect(ctx context.Context) error {
+	var wg sync.WaitGroup
+	var dbErr, redisErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dbErr = ConnectDB(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		redisErr = retryConnect(ctx, "redis", func(ctx context.Context) error {
+			conn, err := openRedis(ctx, redisConf())
+			if err != nil {
+				return err
+			}
+			redisConn.Store(conn)
+			return nil
+		})
+	}()
+	wg.Wait()
+	return errors.Join(dbErr, redisErr)
+}
+
+// ConnectDB 只连接 mysql，用于不需要 redis 的命令行工具，失败时同样按指数退避重试
+func ConnectDB(ctx context.Context) error {
+	return retryConnect(ctx, "mysql", func(ctx context.Context) error {
+		conn, err := openDB(ctx, dbConf())
+		if err != nil {
+			return err
+		}
+		db.Store(conn)
+		return nil
+	})
+}
+
+// retryConnect 每次重试都重新读取配置，密钥文件在重试期间更新也能用上新密码
+func retryConnect(ctx context.Context, backend string, connect func(ctx context.Context) error) error {
+	delay := minConnectDelay
+	for attempt := 1; ; attempt++ {
+		err := connect(ctx)
+		if err == nil {
+			log.WithFields(log.Fields{"backend": backend, "attempts": attempt}).Info("connected")
+			return nil
+		}
+		log.WithError(err).WithFields(log.Fields{"backend": backend, "attempt": attempt, "retry_in": delay}).
+			Warn("connect failed, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return &ConnectError{Backend: backend, Attempts: attempt, Err: err}
+		}
+		delay *= 2
+		if delay > maxConnectDelay {
+			delay = maxConnectDelay
+		}
+	}
+}
+
+// dbConf 返回 mysql 配置，密钥文件更新过时使用最新的密码
+func dbConf() config.DbConf {
+	conf := config.GetGlobalConf().DbConfig
+	if p := latestDbPassword.Load(); p != nil {
+		conf.Password = *p
+	}
+	return conf
+}
+
+// redisConf 返回 redis 配置，密钥文件更新过时使用最新的密码
+func redisConf() config.RedisConf {
+	conf := config.GetGlobalConf().RedisConfig
+	if p := latestRedisPassword.Load(); p != nil {
+		conf.PassWord = *p
+	}
+	return conf
+}
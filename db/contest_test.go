@@ -0,0 +1,27 @@
+package db_test
+
+import (
+	"VoteMe/config"
+	"VoteMe/contest"
+	"VoteMe/db"
+	"VoteMe/keys"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 测试 redis 中的活动状态缓存被清空后从 mysql 恢复，而不是按默认状态开放
+func TestContestSurvivesCacheLoss(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, contest.Migrate(ctx))
+	assert.Nil(t, contest.Init(ctx, config.ContestConf{}))
+	before, err := contest.Get(ctx)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.GetRedisCLi().Del(ctx, keys.Contest()).Err())
+	after, err := contest.Get(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, before.State, after.State)
+	assert.Equal(t, before.Reason, after.Reason)
+	assert.Equal(t, int64(1), db.GetRedisCLi().Exists(ctx, keys.Contest()).Val(), "缓存重新写入")
+}
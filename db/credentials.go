@@ -0,0 +1,63 @@
+package db
+
+import (
+	"VoteMe/config"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	reconnectGracePeriod = 10 * time.Second // 替换连接后旧连接延迟关闭，让正在执行的请求有时间完成
+	reconnectTimeout     = 10 * time.Second // 使用新密码建立连接的最长时间
+)
+
+// 密钥文件更新后的最新密码，之后的重连都使用最新密码
+var (
+	latestDbPassword    atomic.Pointer[config.Secret]
+	latestRedisPassword atomic.Pointer[config.Secret]
+)
+
+// ReloadOnSecretChange 密钥文件中的密码变化后，使用新密码重新建立连接并替换旧连接
+// 新连接建立失败时继续使用旧连接
+func ReloadOnSecretChange() {
+	config.OnSecretChange(func(field string, value config.Secret) {
+		switch field {
+		case "db.password":
+			latestDbPassword.Store(&value)
+			ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+			defer cancel()
+			conn, err := openDB(ctx, dbConf())
+			if err != nil {
+				log.Printf("reconnect mysql with new password failed: %v", err)
+				return
+			}
+			old := db.Swap(conn)
+			time.AfterFunc(reconnectGracePeriod, func() {
+				if err := closeDB(old); err != nil {
+					log.Warnf("close old mysql connection failed: %v", err)
+				}
+			})
+			log.Println("mysql reconnected with new password")
+		case "redis.passwd":
+			latestRedisPassword.Store(&value)
+			ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+			defer cancel()
+			conn, err := openRedis(ctx, redisConf())
+			if err != nil {
+				log.Printf("reconnect redis with new password failed: %v", err)
+				return
+			}
+			old := redisConn.Swap(conn)
+			if old != nil {
+				time.AfterFunc(reconnectGracePeriod, func() {
+					if err := old.Close(); err != nil {
+						log.Warnf("close old redis connection failed: %v", err)
+					}
+				})
+			}
+			log.Println("redis reconnected with new password")
+		}
+	})
+}
@@ -2,51 +2,77 @@ package db
 
 import (
 	"VoteMe/config"
+	"VoteMe/tracing"
+	"context"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"log"
-	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var (
-	db     *gorm.DB
-	dbOnce sync.Once
-)
+var db atomic.Pointer[gorm.DB] // 密码变化后会替换为新的连接
 
-// InitDB 初始化数据库连接
-func initDB() {
-	mysqlConf := config.GetGlobalConf().DbConfig
-	var err error
+// openDB 按配置创建数据库连接池，并用 ping 确认数据库可用
+func openDB(ctx context.Context, mysqlConf config.DbConf) (*gorm.DB, error) {
 	// 数据源
 	dsn := fmt.Sprintf("%s:%s@(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local",
-		mysqlConf.User, mysqlConf.Password, mysqlConf.Host, mysqlConf.Port, mysqlConf.Dbname)
+		mysqlConf.User, mysqlConf.Password.Reveal(), mysqlConf.Host, mysqlConf.Port, mysqlConf.Dbname)
 	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer（日志输出的地方）
+		log.StandardLogger(), // 与其他模块使用同一个 logger，格式和级别统一
 		logger.Config{
 			SlowThreshold: time.Millisecond * 2000, // 慢SQL阈值设置为200毫秒
 			LogLevel:      logger.Warn,             // 日志级别
-			Colorful:      true,                    // 彩色打印
+			Colorful:      false,                   // 不输出颜色控制符，避免污染 json 日志
 		},
 	)
 	// 使用gorm.Open创建数据库连接
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: newLogger,
+	conn, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger:               newLogger,
+		DisableAutomaticPing: true, // 下面使用带超时的 ping
 	})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err) // 连接失败，记录日志并终止程序
+		return nil, err
+	}
+	// 每条 SQL 一个 span
+	if err := conn.Use(tracing.GormPlugin{}); err != nil {
+		return nil, err
 	}
 	// 在InitDB函数中添加Ticket自动迁移
 	//DB.AutoMigrate(&User{}, &Ticket{})
-	sqlDB, err := db.DB()
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
 	sqlDB.SetMaxIdleConns(mysqlConf.MaxIdleConn)                                        // 最大空闲连接
 	sqlDB.SetMaxOpenConns(mysqlConf.MaxOpenConn)                                        // 最大打开连接
 	sqlDB.SetConnMaxLifetime(time.Duration(mysqlConf.MaxIdleTime * int64(time.Second))) // 最大空闲时间（s）
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return conn, nil
 }
+
+// GetDB 返回数据库连接池，Connect 成功之前返回 nil
 func GetDB() *gorm.DB {
-	dbOnce.Do(initDB)
-	return db
+	return db.Load()
+}
+
+// CloseDB 关闭数据库连接池
+func CloseDB() error {
+	return closeDB(db.Load())
+}
+
+func closeDB(conn *gorm.DB) error {
+	if conn == nil {
+		return nil
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
@@ -1,10 +1,12 @@
-package db
+package db_test
 
 import (
 	"VoteMe/config"
 	"VoteMe/control"
+	"VoteMe/db"
 	"VoteMe/model"
 	"VoteMe/utils"
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"runtime"
@@ -16,11 +18,11 @@ import (
 // 测试多机竞争问题
 func TestConcurrencyUpdateUserVotes(t *testing.T) {
 	fmt.Println(runtime.GOMAXPROCS(0))
-	GetDB()       // 初始化数据库
-	GetRedisCLi() // 初始化Redis
+	db.GetDB()       // 初始化数据库
+	db.GetRedisCLi() // 初始化Redis
 
 	userName := "Bob"
-	initialVotes, err := control.GetUserVotes(userName)
+	initialVotes, err := control.GetUserVotes(context.Background(), userName)
 	assert.NoError(t, err)
 
 	var wg sync.WaitGroup
@@ -29,30 +31,30 @@ func TestConcurrencyUpdateUserVotes(t *testing.T) {
 	for i := 0; i < votesToAdd; i++ {
 		go func() {
 			defer wg.Done()
-			err := control.VoteForUserRedis(userName)
+			err := control.VoteForUserRedis(context.Background(), userName)
 			assert.NoError(t, err)
 		}()
 	}
-	time.Sleep(config.VotesCacheToDbTime)
+	time.Sleep(config.Current().VotesCacheToDbTime)
 	wg.Wait()
-	finalVotes, err := control.GetUserVotes(userName)
+	finalVotes, err := control.GetUserVotes(context.Background(), userName)
 	assert.NoError(t, err)
 
 	assert.Equal(t, initialVotes+votesToAdd, finalVotes, "User votes should accurately reflect the number of votes added in a concurrent environment")
 }
 
 func TestUpdateUserVotesExecutionTime(t *testing.T) {
-	defer GetDB().Exec("DELETE FROM users where name = 'TestUser'") // 测试完成后清理数据
+	defer db.GetDB().Exec("DELETE FROM users where name = 'TestUser'") // 测试完成后清理数据
 
 	// 首先创建一个测试用户
 	user := model.User{Name: "TestUser", Votes: 0}
-	if err := GetDB().Create(&user).Error; err != nil {
+	if err := db.GetDB().Create(&user).Error; err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
 	startTime := time.Now() // 开始时间
 	// 调用UpdateUserVotes函数
-	err := control.UpdateUserVotesDirectSQL("TestUser")
+	err := control.UpdateUserVotesDirectSQL(context.Background(), "TestUser")
 	duration := time.Since(startTime) // 计算执行时间
 
 	// 打印执行时间
@@ -64,7 +66,7 @@ func TestUpdateUserVotesExecutionTime(t *testing.T) {
 
 	// 验证投票数增加了1
 	var updatedUser model.User
-	if err := GetDB().Where("name = ?", "TestUser").First(&updatedUser).Error; err != nil {
+	if err := db.GetDB().Where("name = ?", "TestUser").First(&updatedUser).Error; err != nil {
 		t.Fatalf("Failed to query updated user: %v", err)
 	}
 
@@ -74,7 +76,7 @@ func TestUpdateUserVotesExecutionTime(t *testing.T) {
 }
 
 func TestGetUserVotesPerformance(t *testing.T) {
-	GetDB() // 初始化数据库
+	db.GetDB() // 初始化数据库
 	fmt.Println(config.GetGlobalConf().DbConfig.MaxOpenConn)
 	// 假设 "Alice" 是数据库中一个有效的用户名
 	userName := "Alice"
@@ -91,7 +93,7 @@ func TestGetUserVotesPerformance(t *testing.T) {
 	for i := 0; i < votesToAdd; i++ {
 		go func() {
 			defer wg.Done()
-			_, err := control.GetUserVotes(userName)
+			_, err := control.GetUserVotes(context.Background(), userName)
 			assert.NoError(t, err)
 		}()
 	}
@@ -0,0 +1,25 @@
+package db_test
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// 这里的测试需要真实的 mysql 和 redis，先按配置建立连接
+func TestMain(m *testing.M) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetGlobalConf().AppConfig.StartupTimeout)
+	err := db.Connect(ctx)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect storage failed: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	db.CloseRedis()
+	db.CloseDB()
+	os.Exit(code)
+}
@@ -2,36 +2,44 @@ package db
 
 import (
 	"VoteMe/config"
+	"VoteMe/tracing"
 	"context"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"log"
-	"sync"
+	"sync/atomic"
 )
 
-var (
-	redisConn *redis.Client
-	redisOnce sync.Once
-)
+var redisConn atomic.Pointer[redis.Client] // 密码变化后会替换为新的连接
 
-func initRedis() {
-	redisConfig := config.GetGlobalConf().RedisConfig
+// openRedis 按配置创建 redis 连接池，并用 PING 确认 redis 可用
+func openRedis(ctx context.Context, redisConfig config.RedisConf) (*redis.Client, error) {
 	addr := fmt.Sprintf("%s:%d", redisConfig.Host, redisConfig.Port)
-	redisConn = redis.NewClient(&redis.Options{
+	conn := redis.NewClient(&redis.Options{
 		Addr:         addr,
-		Password:     redisConfig.PassWord,
+		Password:     redisConfig.PassWord.Reveal(),
 		DB:           redisConfig.DB,
 		PoolSize:     redisConfig.PoolSile,
-		MinIdleConns: config.MinIdleCoons,
+		MinIdleConns: redisConfig.MinIdleConn,
 	})
+	conn.AddHook(tracing.RedisHook{}) // 每条命令一个 span
 
-	// 连接测试以确保与 Redis 服务器的通信正常。
-	_, err := redisConn.Set(context.Background(), "abc", 100, 60).Result()
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	// 连接测试以确保与 Redis 服务器的通信正常，不写入任何键
+	if err := conn.Ping(ctx).Err(); err != nil {
+		conn.Close()
+		return nil, err
 	}
+	return conn, nil
 }
+
+// GetRedisCLi 返回 redis 连接池，Connect 成功之前返回 nil
 func GetRedisCLi() *redis.Client {
-	redisOnce.Do(initRedis)
-	return redisConn
+	return redisConn.Load()
+}
+
+// CloseRedis 关闭 redis 连接池
+func CloseRedis() error {
+	if conn := redisConn.Load(); conn != nil {
+		return conn.Close()
+	}
+	return nil
 }
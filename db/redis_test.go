@@ -1,8 +1,11 @@
-package db
+package db_test
 
 import (
 	"VoteMe/control"
+	"VoteMe/db"
+	"VoteMe/keys"
 	"VoteMe/utils"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"sync"
 	"testing"
@@ -13,14 +16,14 @@ import (
 func TestTicketUsage(t *testing.T) {
 	ticketID := utils.GetCurrentTicket()
 	maxVotes, ticketUpdateTime := 200, 5*time.Second
-	err := control.SetValidateTicket(ticketID, maxVotes, ticketUpdateTime)
+	err := control.SetValidateTicket(context.Background(), ticketID, maxVotes, ticketUpdateTime)
 	assert.Nil(t, err)
 	t.Log(ticketID)
 	//time.Sleep(ticketUpdateTime)
 	//err = db.GetRedisCLi().Get(context.Background(), ticketID).Err()
 	//assert.NotNil(t, err)
 	for i := 0; i < maxVotes; i++ {
-		err := control.DecreaseUsageLimit(ticketID)
+		err := control.DecreaseUsageLimit(context.Background(), ticketID)
 		assert.Nil(t, err)
 
 		//remaining, err := db.GetRedisCLi().Get(context.Background(), ticketID).Int()
@@ -28,15 +31,38 @@ func TestTicketUsage(t *testing.T) {
 		//assert.Equal(t, maxVotes-i-1, remaining, "剩余次数不匹配")
 	}
 	//再次减少应达到上限
-	err = control.DecreaseUsageLimit(ticketID)
-	assert.NotNil(t, err)
+	err = control.DecreaseUsageLimit(context.Background(), ticketID)
+	assert.ErrorIs(t, err, control.ErrTicketExhausted)
+}
+
+// 测试伪造的票据返回 ErrTicketUnknown，并且不会在 redis 中留下键
+func TestUnknownTicket(t *testing.T) {
+	ticketID := "forged-ticket"
+	err := control.DecreaseUsageLimit(context.Background(), ticketID)
+	assert.ErrorIs(t, err, control.ErrTicketUnknown)
+	n, err := db.GetRedisCLi().Exists(context.Background(), keys.Ticket(ticketID)).Result()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+// 测试每个投票人单独计数，一个投票人用完自己的次数后其他投票人仍然可以使用同一张票据
+func TestVoterTicketUsage(t *testing.T) {
+	ctx := context.Background()
+	ticketID := "voter-" + time.Now().Format("150405.000000")
+	assert.Nil(t, control.SetValidateTicket(ctx, ticketID, 10, 5*time.Second))
+	assert.Nil(t, control.DecreaseVoterUsage(ctx, ticketID, "alice", 1))
+	assert.ErrorIs(t, control.DecreaseVoterUsage(ctx, ticketID, "alice", 1), control.ErrVoterExhausted)
+	assert.Nil(t, control.DecreaseVoterUsage(ctx, ticketID, "bob", 1))
+	uses, err := control.TicketUses(ctx, ticketID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, uses, "被拒绝的投票不计入使用次数")
 }
 
 // 测试将获得票的数据插入redis是否正常，并且在过期后能否从数据库重新获取，并加载
 func TestGetVotesByName(t *testing.T) {
 	name := "Alice"
 	for i := 0; i < 1000; i++ {
-		votes, err := control.GetVotesByName(name)
+		votes, err := control.GetVotesByName(context.Background(), name)
 		assert.Nil(t, err)
 		assert.Equal(t, 106803, votes)
 	}
@@ -50,7 +76,7 @@ func TestVoteForUser(t *testing.T) {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			control.VoteForUserRedis(name)
+			control.VoteForUserRedis(context.Background(), name)
 		}()
 	}
 	wg.Wait()
@@ -0,0 +1,45 @@
+package db_test
+
+import (
+	"VoteMe/control"
+	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/model"
+	"VoteMe/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// 测试两次刷盘同时执行时，每一票只会写入 mysql 一次
+func TestSyncVotesConcurrent(t *testing.T) {
+	ctx := context.Background()
+	name := "Alice"
+	var before model.User
+	assert.Nil(t, db.GetDB().Where("name = ?", name).Take(&before).Error)
+	// 先把已有的增量刷掉，再投 n 票
+	assert.Nil(t, utils.SyncVotes(ctx))
+	assert.Nil(t, db.GetDB().Where("name = ?", name).Take(&before).Error)
+	n := 50
+	for i := 0; i < n; i++ {
+		assert.Nil(t, control.VoteForUserRedis(ctx, name))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, utils.SyncVotes(ctx))
+		}()
+	}
+	wg.Wait()
+
+	var after model.User
+	assert.Nil(t, db.GetDB().Where("name = ?", name).Take(&after).Error)
+	assert.Equal(t, before.Votes+n, after.Votes)
+	pending, err := db.GetRedisCLi().Get(ctx, keys.Votes(name)).Int()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending)
+}
@@ -0,0 +1,49 @@
+package db_test
+
+import (
+	"VoteMe/control"
+	"VoteMe/db"
+	"VoteMe/model"
+	"VoteMe/tickets"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// 测试票据过期后使用次数从 redis 复制到 tickets 表，并能按时间段统计
+func TestTicketHistory(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, tickets.Migrate(ctx))
+	ticketID := "history-" + time.Now().Format("150405.000000")
+	start := time.Now().Add(-time.Second)
+	assert.Nil(t, control.SetValidateTicket(ctx, ticketID, 5, time.Second))
+	assert.Nil(t, tickets.Record(ctx, ticketID, 5, time.Second))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, control.DecreaseUsageLimit(ctx, ticketID))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	_, err := tickets.FinalizeExpired(ctx)
+	assert.Nil(t, err)
+	var ticket model.Ticket
+	assert.Nil(t, db.GetDB().Where("ticket_id = ?", ticketID).Take(&ticket).Error)
+	assert.Equal(t, 3, ticket.Uses)
+	assert.True(t, ticket.Finalized)
+	assert.Equal(t, tickets.Instance(), ticket.Instance)
+
+	usage, err := tickets.Usage(ctx, start, time.Now(), time.Hour)
+	assert.Nil(t, err)
+	if assert.Len(t, usage, 1) {
+		assert.GreaterOrEqual(t, usage[0].Uses, int64(3))
+	}
+
+	// 数据库是共享的，把测试票据的签发时间改到很久以前，只清理早于它之后一秒的记录，不影响其他票据
+	issuedAt := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, db.GetDB().Model(&model.Ticket{}).Where("ticket_id = ?", ticketID).Update("issued_at", issuedAt).Error)
+	_, err = tickets.Prune(ctx, issuedAt.Add(time.Second))
+	assert.Nil(t, err)
+	var left int64
+	assert.Nil(t, db.GetDB().Unscoped().Model(&model.Ticket{}).Where("ticket_id = ?", ticketID).Count(&left).Error)
+	assert.Equal(t, int64(0), left)
+}
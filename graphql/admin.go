@@ -0,0 +1,495 @@
+package graphql
+
+import (
+	"VoteMe/admin"
+	"VoteMe/config"
+	"VoteMe/contest"
+	"VoteMe/metrics"
+	"VoteMe/results"
+	"VoteMe/tickets"
+	"VoteMe/tracing"
+	"VoteMe/utils"
+	"context"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"time"
+)
+
+// 管理接口的 schema，与投票接口分开，只在 admin.addr 上提供
+// 查询需要 viewer 角色，换发票据、开放/暂停/恢复投票、刷盘需要 operator 角色，
+// 结束投票、修改计划时间、认证结果、修改 maxVotes 和查看审计日志需要 admin 角色
+
+// 定义GraphQL中的刷盘状态类型
+var flushStatusType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "FlushStatus",
+		Fields: graphql.Fields{
+			"lastSyncAt": &graphql.Field{Type: graphql.String},  // 当前实例最近一次成功刷盘的时间，从未刷盘时为空
+			"lagSeconds": &graphql.Field{Type: graphql.Float},   // 距离最近一次成功刷盘过去了多少秒
+			"maxSyncLag": &graphql.Field{Type: graphql.String},  // 超过该时间没有刷盘，实例就不再是就绪状态
+			"healthy":    &graphql.Field{Type: graphql.Boolean}, // 刷盘延迟是否在 maxSyncLag 以内
+		},
+	},
+)
+
+// 定义GraphQL中的票据使用情况类型，一个时间段内签发的票据汇总为一条
+var ticketUsageType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "TicketUsage",
+		Fields: graphql.Fields{
+			"start":   &graphql.Field{Type: graphql.String}, // 时间段的开始时间
+			"tickets": &graphql.Field{Type: graphql.Int},    // 签发的票据数
+			"uses":    &graphql.Field{Type: graphql.Int},    // 使用次数，票据过期后才会计入
+			"maxUses": &graphql.Field{Type: graphql.Int},    // 最大使用次数之和
+			"pending": &graphql.Field{Type: graphql.Int},    // 还没有计入使用次数的票据数
+		},
+	},
+)
+
+// 定义GraphQL中的投票活动类型
+var contestType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Contest",
+		Fields: graphql.Fields{
+			"state":     &graphql.Field{Type: graphql.String}, // scheduled、open、paused、closed 或 certified
+			"reason":    &graphql.Field{Type: graphql.String}, // 最近一次变更的原因
+			"startsAt":  &graphql.Field{Type: graphql.String}, // 计划开始时间，为空表示需要手动开放
+			"endsAt":    &graphql.Field{Type: graphql.String}, // 计划结束时间，为空表示需要手动结束
+			"updatedAt": &graphql.Field{Type: graphql.String},
+			"updatedBy": &graphql.Field{Type: graphql.String}, // 操作人，按计划时间变更时为 schedule
+		},
+	},
+)
+
+// 定义GraphQL中的认证结果类型
+// json 和 csv 为签名后的导出文件，第三方可以使用 voteme verify-results 离线校验
+var certificationType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Certification",
+		Fields: graphql.Fields{
+			"contest":     &graphql.Field{Type: graphql.String},
+			"closedAt":    &graphql.Field{Type: graphql.String},
+			"certifiedAt": &graphql.Field{Type: graphql.String},
+			"certifiedBy": &graphql.Field{Type: graphql.String},
+			"total":       &graphql.Field{Type: graphql.Int},
+			"results":     &graphql.Field{Type: graphql.NewList(userType)},       // 每个选手的最终票数
+			"mode":        &graphql.Field{Type: graphql.String},                  // 投票方式
+			"winners":     &graphql.Field{Type: graphql.NewList(graphql.String)}, // 当选者
+			"rounds":      &graphql.Field{Type: graphql.NewList(roundType)},      // 即时决选的每一轮，只有排序复选才有
+			"ballots":     &graphql.Field{Type: graphql.Int},                     // 排序复选参与计算的选票数
+			"sha256":      &graphql.Field{Type: graphql.String},                  // 规范化结果的哈希
+			"signature":   &graphql.Field{Type: graphql.String},                  // Ed25519 签名，base64
+			"publicKey":   &graphql.Field{Type: graphql.String},                  // 签名公钥，base64
+			"json":        &graphql.Field{Type: graphql.String},
+			"csv":         &graphql.Field{Type: graphql.String},
+		},
+	},
+)
+
+// 定义GraphQL中的运行时配置类型，用于查看当前生效的配置版本
+var settingsType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Settings",
+		Fields: graphql.Fields{
+			"version":                &graphql.Field{Type: graphql.Int},    // 配置版本号，每次热更加一
+			"loadedAt":               &graphql.Field{Type: graphql.String}, // 加载时间
+			"maxVotes":               &graphql.Field{Type: graphql.Int},    // 票据最大使用次数
+			"ticketUpdateTime":       &graphql.Field{Type: graphql.String}, // 票据更新时间
+			"ticketCacheRefreshTime": &graphql.Field{Type: graphql.String}, // 票数缓存刷新时间
+			"votesCacheToDbTime":     &graphql.Field{Type: graphql.String}, // 刷盘时间
+			"ticketLen":              &graphql.Field{Type: graphql.Int},    // 票据长度
+			"goGc":                   &graphql.Field{Type: graphql.Int},    // gc 步调
+		},
+	},
+)
+
+// 定义GraphQL中的运行状态类型
+var adminStatusType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "AdminStatus",
+		Fields: graphql.Fields{
+			"contest":  &graphql.Field{Type: contestType},  // 投票活动状态，所有实例共享
+			"settings": &graphql.Field{Type: settingsType}, // 当前实例生效的运行时配置
+			"flush":    &graphql.Field{Type: flushStatusType},
+		},
+	},
+)
+
+// 定义GraphQL中的审计日志类型
+var auditLogType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "AuditLog",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.Int},
+			"createdAt":  &graphql.Field{Type: graphql.String},
+			"actor":      &graphql.Field{Type: graphql.String},
+			"role":       &graphql.Field{Type: graphql.String},
+			"action":     &graphql.Field{Type: graphql.String},
+			"args":       &graphql.Field{Type: graphql.String}, // JSON
+			"result":     &graphql.Field{Type: graphql.String}, // started、ok、error 或 denied
+			"error":      &graphql.Field{Type: graphql.String},
+			"remoteAddr": &graphql.Field{Type: graphql.String},
+			"requestID":  &graphql.Field{Type: graphql.String},
+		},
+	},
+)
+
+var adminQueryType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "AdminQuery",
+		Fields: graphql.Fields{
+			"status": &graphql.Field{ // 运行状态
+				Type: adminStatusType,
+				Resolve: instrument("admin.status", func(params graphql.ResolveParams) (interface{}, error) {
+					if _, err := admin.Require(params.Context, admin.RoleViewer); err != nil {
+						return nil, err
+					}
+					c, err := contest.Get(params.Context)
+					if err != nil {
+						return nil, fmt.Errorf("failed to get contest state: %w", err)
+					}
+					return map[string]interface{}{
+						"contest":  contestResult(c),
+						"settings": settingsResult(config.Current()),
+						"flush":    flushStatus(),
+					}, nil
+				}),
+			},
+			"flushStatus": &graphql.Field{ // 刷盘状态
+				Type: flushStatusType,
+				Resolve: instrument("admin.flushStatus", func(params graphql.ResolveParams) (interface{}, error) {
+					if _, err := admin.Require(params.Context, admin.RoleViewer); err != nil {
+						return nil, err
+					}
+					return flushStatus(), nil
+				}),
+			},
+			"certifiedResults": &graphql.Field{ // 已认证的最终结果，还没有认证时为 null
+				Type: certificationType,
+				Resolve: instrument("admin.certifiedResults", func(params graphql.ResolveParams) (interface{}, error) {
+					if _, err := admin.Require(params.Context, admin.RoleViewer); err != nil {
+						return nil, err
+					}
+					e, err := results.Latest(params.Context)
+					if err != nil || e == nil {
+						return nil, err
+					}
+					return certificationResult(e)
+				}),
+			},
+			"ticketUsage": &graphql.Field{ // 按签发时间统计票据的使用情况，默认最近 24 小时每小时一段
+				Type: graphql.NewList(ticketUsageType),
+				Args: graphql.FieldConfigArgument{
+					"from":   &graphql.ArgumentConfig{Type: graphql.String}, // RFC3339
+					"to":     &graphql.ArgumentConfig{Type: graphql.String}, // RFC3339
+					"bucket": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "1h"},
+				},
+				Resolve: instrument("admin.ticketUsage", func(params graphql.ResolveParams) (interface{}, error) {
+					if _, err := admin.Require(params.Context, admin.RoleViewer); err != nil {
+						return nil, err
+					}
+					to := time.Now()
+					if s, _ := params.Args["to"].(string); s != "" {
+						t, err := time.Parse(time.RFC3339, s)
+						if err != nil {
+							return nil, fmt.Errorf("to must be an RFC3339 time: %w", err)
+						}
+						to = t
+					}
+					from := to.Add(-24 * time.Hour)
+					if s, _ := params.Args["from"].(string); s != "" {
+						t, err := time.Parse(time.RFC3339, s)
+						if err != nil {
+							return nil, fmt.Errorf("from must be an RFC3339 time: %w", err)
+						}
+						from = t
+					}
+					s, _ := params.Args["bucket"].(string)
+					bucket, err := time.ParseDuration(s)
+					if err != nil {
+						return nil, fmt.Errorf("bucket must be a duration such as 1h: %w", err)
+					}
+					usage, err := tickets.Usage(params.Context, from, to, bucket)
+					if err != nil {
+						return nil, fmt.Errorf("failed to query ticket usage: %w", err)
+					}
+					result := make([]map[string]interface{}, 0, len(usage))
+					for _, b := range usage {
+						result = append(result, map[string]interface{}{
+							"start":   b.Start.Format(time.RFC3339),
+							"tickets": b.Tickets,
+							"uses":    b.Uses,
+							"maxUses": b.MaxUses,
+							"pending": b.Pending,
+						})
+					}
+					return result, nil
+				}),
+			},
+			"auditLog": &graphql.Field{ // 最近的审计日志
+				Type: graphql.NewList(auditLogType),
+				Args: graphql.FieldConfigArgument{
+					"action": &graphql.ArgumentConfig{Type: graphql.String},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 50},
+				},
+				Resolve: instrument("admin.auditLog", func(params graphql.ResolveParams) (interface{}, error) {
+					if _, err := admin.Require(params.Context, admin.RoleAdmin); err != nil {
+						return nil, err
+					}
+					action, _ := params.Args["action"].(string)
+					limit, _ := params.Args["limit"].(int)
+					if limit <= 0 || limit > 1000 {
+						return nil, fmt.Errorf("limit must be between 1 and 1000")
+					}
+					logs, err := admin.Recent(params.Context, action, limit)
+					if err != nil {
+						return nil, fmt.Errorf("failed to query audit log: %w", err)
+					}
+					result := make([]map[string]interface{}, 0, len(logs))
+					for _, l := range logs {
+						result = append(result, map[string]interface{}{
+							"id":         l.ID,
+							"createdAt":  l.CreatedAt.Format(time.RFC3339),
+							"actor":      l.Actor,
+							"role":       l.Role,
+							"action":     l.Action,
+							"args":       l.Args,
+							"result":     l.Result,
+							"error":      l.Error,
+							"remoteAddr": l.RemoteAddr,
+							"requestID":  l.RequestID,
+						})
+					}
+					return result, nil
+				}),
+			},
+		},
+	},
+)
+
+var adminMutationType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "AdminMutation",
+		Fields: graphql.Fields{
+			"rotateTicket": &graphql.Field{ // 立即换发当前实例的票据
+				Type: graphql.Boolean,
+				Resolve: adminAction("rotateTicket", admin.RoleOperator, func(ctx context.Context, _ map[string]interface{}) (interface{}, error) {
+					if err := utils.RotateTicket(ctx); err != nil {
+						return false, err
+					}
+					return true, nil
+				}),
+			},
+			"openVoting": &graphql.Field{ // 提前开放投票，scheduled → open
+				Type:    contestType,
+				Args:    reasonArgs(),
+				Resolve: transition("openVoting", admin.RoleOperator, contest.Open),
+			},
+			"pauseVoting": &graphql.Field{ // 暂停投票，open → paused，所有实例同时生效
+				Type:    contestType,
+				Args:    reasonArgs(),
+				Resolve: transition("pauseVoting", admin.RoleOperator, contest.Paused),
+			},
+			"resumeVoting": &graphql.Field{ // 恢复投票，paused → open
+				Type:    contestType,
+				Args:    reasonArgs(),
+				Resolve: transition("resumeVoting", admin.RoleOperator, contest.Open),
+			},
+			"closeVoting": &graphql.Field{ // 结束投票，open 或 paused → closed，结束后不能重新开放
+				Type:    contestType,
+				Args:    reasonArgs(),
+				Resolve: transition("closeVoting", admin.RoleAdmin, contest.Closed),
+			},
+			"scheduleVoting": &graphql.Field{ // 修改计划开始和结束时间，RFC3339 格式，为空表示取消
+				Type: contestType,
+				Args: graphql.FieldConfigArgument{
+					"startsAt": &graphql.ArgumentConfig{Type: graphql.String},
+					"endsAt":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: adminAction("scheduleVoting", admin.RoleAdmin, func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+					startsAt, _ := args["startsAt"].(string)
+					endsAt, _ := args["endsAt"].(string)
+					start, end, err := config.ContestConf{StartsAt: startsAt, EndsAt: endsAt}.Schedule()
+					if err != nil {
+						return nil, fmt.Errorf("startsAt and endsAt must be RFC3339 times: %w", err)
+					}
+					p, _ := admin.FromContext(ctx)
+					c, err := contest.SetSchedule(ctx, start, end, p.Subject)
+					if err != nil {
+						return nil, err
+					}
+					return contestResult(c), nil
+				}),
+			},
+			"flushVotes": &graphql.Field{ // 立即把当前 redis 中的增量刷入 mysql
+				Type: flushStatusType,
+				Resolve: adminAction("flushVotes", admin.RoleOperator, func(ctx context.Context, _ map[string]interface{}) (interface{}, error) {
+					if err := utils.SyncVotes(ctx); err != nil {
+						return nil, err
+					}
+					return flushStatus(), nil
+				}),
+			},
+			"certifyResults": &graphql.Field{ // 投票结束后认证最终结果：刷盘、快照票数、签名，活动转为 certified
+				Type: certificationType,
+				Resolve: adminAction("certifyResults", admin.RoleAdmin, func(ctx context.Context, _ map[string]interface{}) (interface{}, error) {
+					p, _ := admin.FromContext(ctx)
+					e, err := results.Certify(ctx, p.Subject)
+					if err != nil {
+						return nil, err
+					}
+					return certificationResult(e)
+				}),
+			},
+			"setMaxVotes": &graphql.Field{ // 修改票据最大使用次数，只对当前实例生效，下一张票据开始使用；配置文件热更后以文件为准
+				Type: settingsType,
+				Args: graphql.FieldConfigArgument{
+					"maxVotes": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: adminAction("setMaxVotes", admin.RoleAdmin, func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+					maxVotes, _ := args["maxVotes"].(int)
+					s, err := config.Update(func(s *config.Settings) { s.MaxVotes = maxVotes })
+					if err != nil {
+						return nil, err
+					}
+					return settingsResult(s), nil
+				}),
+			},
+		},
+	},
+)
+
+// adminAction 包装管理接口的变更操作：记录耗时和 span，检查角色并写入审计日志
+func adminAction(action string, role admin.Role, run func(ctx context.Context, args map[string]interface{}) (interface{}, error)) graphql.FieldResolveFn {
+	return instrument("admin."+action, func(params graphql.ResolveParams) (interface{}, error) {
+		return admin.Audit(params.Context, role, action, params.Args, func(ctx context.Context) (interface{}, error) {
+			return run(ctx, params.Args)
+		})
+	})
+}
+
+// transition 管理接口中变更活动状态的操作，reason 必填
+func transition(action string, role admin.Role, to contest.State) graphql.FieldResolveFn {
+	return adminAction(action, role, func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		reason, _ := args["reason"].(string)
+		if reason == "" {
+			return nil, fmt.Errorf("reason is required")
+		}
+		p, _ := admin.FromContext(ctx)
+		c, err := contest.Transition(ctx, to, reason, p.Subject)
+		if err != nil {
+			return nil, err
+		}
+		return contestResult(c), nil
+	})
+}
+
+func reasonArgs() graphql.FieldConfigArgument {
+	return graphql.FieldConfigArgument{
+		"reason": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+	}
+}
+
+// certificationResult 把签名后的结果转换为 Certification 类型的结果
+func certificationResult(e *results.Export) (map[string]interface{}, error) {
+	jsonExport, err := results.EncodeJSON(e)
+	if err != nil {
+		return nil, err
+	}
+	csvExport, err := results.EncodeCSV(e)
+	if err != nil {
+		return nil, err
+	}
+	tallies := make([]map[string]interface{}, 0, len(e.Payload.Results))
+	for _, t := range e.Payload.Results {
+		tallies = append(tallies, map[string]interface{}{"name": t.Candidate, "votes": t.Votes})
+	}
+	rounds := make([]map[string]interface{}, 0, len(e.Payload.Rounds))
+	for _, r := range e.Payload.Rounds {
+		counts := make([]map[string]interface{}, 0, len(r.Counts))
+		for _, t := range r.Counts {
+			counts = append(counts, map[string]interface{}{"name": t.Candidate, "votes": t.Votes})
+		}
+		rounds = append(rounds, map[string]interface{}{
+			"round":      r.Round,
+			"counts":     counts,
+			"eliminated": r.Eliminated,
+			"exhausted":  r.Exhausted,
+		})
+	}
+	return map[string]interface{}{
+		"contest":     e.Payload.Contest,
+		"closedAt":    e.Payload.ClosedAt,
+		"certifiedAt": e.Payload.CertifiedAt,
+		"certifiedBy": e.Payload.CertifiedBy,
+		"total":       e.Payload.Total,
+		"results":     tallies,
+		"mode":        e.Payload.Mode,
+		"winners":     e.Payload.Winners,
+		"rounds":      rounds,
+		"ballots":     e.Payload.Ballots,
+		"sha256":      e.Hash,
+		"signature":   e.Signature,
+		"publicKey":   e.PublicKey,
+		"json":        string(jsonExport),
+		"csv":         string(csvExport),
+	}, nil
+}
+
+// contestResult 把活动状态转换为 Contest 类型的结果
+func contestResult(c contest.Contest) map[string]interface{} {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"state":     string(c.State),
+		"reason":    c.Reason,
+		"startsAt":  format(c.StartsAt),
+		"endsAt":    format(c.EndsAt),
+		"updatedAt": format(c.UpdatedAt),
+		"updatedBy": c.UpdatedBy,
+	}
+}
+
+// settingsResult 把运行时配置转换为 Settings 类型的结果
+func settingsResult(s *config.Settings) map[string]interface{} {
+	return map[string]interface{}{
+		"version":                s.Version,
+		"loadedAt":               s.LoadedAt.Format(time.RFC3339),
+		"maxVotes":               s.MaxVotes,
+		"ticketUpdateTime":       s.TicketsUpdateTime.String(),
+		"ticketCacheRefreshTime": s.TicketCacheRefreshTime.String(),
+		"votesCacheToDbTime":     s.VotesCacheToDbTime.String(),
+		"ticketLen":              s.TicketLen,
+		"goGc":                   s.GoGC,
+	}
+}
+
+func flushStatus() map[string]interface{} {
+	lag := metrics.SyncLag()
+	maxLag := config.Current().MaxSyncLag
+	lastSyncAt := ""
+	if last := metrics.LastSync(); !last.IsZero() {
+		lastSyncAt = last.Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"lastSyncAt": lastSyncAt,
+		"lagSeconds": lag.Seconds(),
+		"maxSyncLag": maxLag.String(),
+		"healthy":    lag <= maxLag, // 从未刷盘时 lag 为 0，按健康处理
+	}
+}
+
+// NewAdminSchema 创建管理接口的 GraphQL schema
+func NewAdminSchema() (graphql.Schema, error) {
+	return graphql.NewSchema(
+		graphql.SchemaConfig{
+			Query:      adminQueryType,
+			Mutation:   adminMutationType,
+			Extensions: []graphql.Extension{tracing.GraphQLExtension{}},
+		},
+	)
+}
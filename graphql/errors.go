@@ -0,0 +1,109 @@
+package graphql
+
+import (
+	"VoteMe/auth"
+	"VoteMe/challenge"
+	"VoteMe/contest"
+	"VoteMe/control"
+	"VoteMe/logging"
+	"VoteMe/tally"
+	"context"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// 错误码，作为 GraphQL 错误的 extensions.code 返回，客户端按错误码处理，不要匹配错误信息
+const (
+	CodeTicketExpired      = "TICKET_EXPIRED"      // 票据已过期，重新获取票据
+	CodeTicketUnknown      = "TICKET_UNKNOWN"      // 票据从未签发过
+	CodeTicketExhausted    = "TICKET_EXHAUSTED"    // 票据的使用次数已用完，等待下一张票据
+	CodeUnknownCandidate   = "UNKNOWN_CANDIDATE"   // 选手不在候选人名单中
+	CodeInvalidBallot      = "INVALID_BALLOT"      // 选票不符合投票规则，extensions.rule 说明是哪条规则
+	CodeInvalidChallenge   = "INVALID_CHALLENGE"   // 挑战不存在、已过期、已使用或答案不对
+	CodeContestNotOpen     = "CONTEST_NOT_OPEN"    // 投票活动还没开始、已暂停或已结束
+	CodeUnauthenticated    = "UNAUTHENTICATED"     // 需要携带 JWT，或者票据不是签发给当前投票人的
+	CodeRateLimited        = "RATE_LIMITED"        // 请求过于频繁，由限流中间件返回 429，extensions.retryAfter 为建议的重试秒数
+	CodeRequestTooLarge    = "REQUEST_TOO_LARGE"   // 请求体超过 1 MiB，由限流中间件返回 413
+	CodeBackendUnavailable = "BACKEND_UNAVAILABLE" // redis、mysql 等依赖出错，可以稍后重试
+	CodeInternal           = "INTERNAL"            // 无法识别的错误，服务端有 bug，重试通常没有用
+)
+
+// errorCodeEnum 在 schema 中公布所有错误码，客户端可以通过内省查到
+var errorCodeEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name:        "ErrorCode",
+	Description: "Values of errors[].extensions.code returned by this API.",
+	Values: graphql.EnumValueConfigMap{
+		CodeTicketExpired:      &graphql.EnumValueConfig{Value: CodeTicketExpired, Description: "The ticket has expired, fetch a new one."},
+		CodeTicketUnknown:      &graphql.EnumValueConfig{Value: CodeTicketUnknown, Description: "The ticket was never issued."},
+		CodeTicketExhausted:    &graphql.EnumValueConfig{Value: CodeTicketExhausted, Description: "The ticket has no uses left, wait for the next ticket."},
+		CodeUnknownCandidate:   &graphql.EnumValueConfig{Value: CodeUnknownCandidate, Description: "A name is not on the candidate list, extensions.candidate is the name."},
+		CodeInvalidBallot:      &graphql.EnumValueConfig{Value: CodeInvalidBallot, Description: "The ballot breaks a voting rule, extensions.rule tells which one."},
+		CodeInvalidChallenge:   &graphql.EnumValueConfig{Value: CodeInvalidChallenge, Description: "The proof-of-work challenge is unknown, expired, used or not solved."},
+		CodeContestNotOpen:     &graphql.EnumValueConfig{Value: CodeContestNotOpen, Description: "Voting has not started, is paused or has ended."},
+		CodeUnauthenticated:    &graphql.EnumValueConfig{Value: CodeUnauthenticated, Description: "A valid JWT is required, or the ticket was issued to another voter."},
+		CodeRateLimited:        &graphql.EnumValueConfig{Value: CodeRateLimited, Description: "Too many requests (HTTP 429), extensions.retryAfter is the suggested wait in seconds."},
+		CodeRequestTooLarge:    &graphql.EnumValueConfig{Value: CodeRequestTooLarge, Description: "The request body is larger than 1 MiB (HTTP 413)."},
+		CodeBackendUnavailable: &graphql.EnumValueConfig{Value: CodeBackendUnavailable, Description: "A storage backend failed, the request can be retried."},
+		CodeInternal:           &graphql.EnumValueConfig{Value: CodeInternal, Description: "An unexpected server error, retrying usually does not help."},
+	},
+})
+
+// codedError 带错误码的 GraphQL 错误，实现了 graphql-go 的 ExtendedError
+type codedError struct {
+	code       string
+	message    string
+	extensions map[string]interface{} // 除 code 之外的附加信息
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+// Extensions 返回 GraphQL 错误的 extensions
+func (e *codedError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.code}
+	for k, v := range e.extensions {
+		ext[k] = v
+	}
+	return ext
+}
+
+// publicError 把内部错误转换为带错误码的 GraphQL 错误
+// 后端错误需要包装为 control.ErrBackendUnavailable；无法识别的错误按内部错误处理，只记录日志，不把内部细节返回给客户端
+func publicError(ctx context.Context, err error) error {
+	var extended gqlerrors.ExtendedError
+	if errors.As(err, &extended) {
+		// 已经带有错误码，例如 *tally.RuleError
+		return extended
+	}
+	var unknown *control.UnknownCandidateError
+	switch {
+	case errors.As(err, &unknown):
+		return &codedError{code: CodeUnknownCandidate, message: err.Error(), extensions: map[string]interface{}{"candidate": unknown.Name}}
+	case errors.Is(err, control.ErrUnknownCandidate):
+		return &codedError{code: CodeUnknownCandidate, message: err.Error()}
+	case errors.Is(err, tally.ErrInvalidBallot):
+		return &codedError{code: CodeInvalidBallot, message: err.Error()}
+	case errors.Is(err, control.ErrTicketExpired):
+		return &codedError{code: CodeTicketExpired, message: control.ErrTicketExpired.Error()}
+	case errors.Is(err, control.ErrTicketUnknown):
+		return &codedError{code: CodeTicketUnknown, message: control.ErrTicketUnknown.Error()}
+	case errors.Is(err, control.ErrTicketExhausted):
+		return &codedError{code: CodeTicketExhausted, message: control.ErrTicketExhausted.Error()}
+	case errors.Is(err, contest.ErrNotOpen):
+		return &codedError{code: CodeContestNotOpen, message: err.Error()}
+	case errors.Is(err, auth.ErrUnauthenticated), errors.Is(err, auth.ErrWrongVoter):
+		return &codedError{code: CodeUnauthenticated, message: err.Error()}
+	case errors.Is(err, challenge.ErrTicketExhausted):
+		return &codedError{code: CodeTicketExhausted, message: err.Error()}
+	case challenge.Rejected(err):
+		return &codedError{code: CodeInvalidChallenge, message: err.Error()}
+	case errors.Is(err, control.ErrBackendUnavailable):
+		logging.FromContext(ctx).WithError(err).Error("backend unavailable")
+		return &codedError{code: CodeBackendUnavailable, message: "backend unavailable, please retry later"}
+	default:
+		logging.FromContext(ctx).WithError(err).Error("internal error")
+		return &codedError{code: CodeInternal, message: "internal error"}
+	}
+}
@@ -0,0 +1,68 @@
+package graphql
+
+import (
+	"VoteMe/config"
+	"VoteMe/contest"
+	"VoteMe/control"
+	"VoteMe/tally"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// code 返回 publicError 转换后的 extensions.code
+func code(err error) interface{} {
+	ext, ok := publicError(context.Background(), err).(interface{ Extensions() map[string]interface{} })
+	if !ok {
+		return nil
+	}
+	return ext.Extensions()["code"]
+}
+
+func TestPublicError(t *testing.T) {
+	assert.Equal(t, CodeTicketExpired, code(control.ErrTicketExpired))
+	assert.Equal(t, CodeTicketUnknown, code(control.ErrTicketUnknown))
+	assert.Equal(t, CodeTicketExhausted, code(control.ErrTicketExhausted))
+	assert.Equal(t, CodeContestNotOpen, code(fmt.Errorf("%w: paused", contest.ErrNotOpen)))
+	assert.Equal(t, CodeBackendUnavailable, code(fmt.Errorf("%w: decrease ticket usage: i/o timeout", control.ErrBackendUnavailable)))
+	assert.Equal(t, CodeInternal, code(errors.New("unexpected nil pointer")), "无法识别的错误按内部错误处理")
+	assert.Equal(t, CodeInvalidBallot, code(fmt.Errorf("%w: empty ranking", tally.ErrInvalidBallot)))
+
+	control.SetCandidates([]string{"alice"})
+	defer control.SetCandidates(nil)
+	assert.NoError(t, control.CheckCandidates([]string{"alice"}))
+	err := publicError(context.Background(), control.CheckCandidates([]string{"alice", "mallory"}))
+	assert.Equal(t, map[string]interface{}{"code": CodeUnknownCandidate, "candidate": "mallory"}, err.(*codedError).Extensions())
+
+	// 已经带有错误码的错误原样返回
+	assert.Equal(t, CodeInvalidBallot, code(tally.Validate(config.VotingConf{Mode: config.VotingSingle}, nil)))
+
+	// 后端错误不把内部细节返回给客户端
+	assert.NotContains(t, publicError(context.Background(), errors.New("dial tcp 10.0.0.1:6379")).Error(), "10.0.0.1")
+}
+
+// 测试错误码出现在 GraphQL 响应的 extensions 中，并且可以通过内省查到
+func TestErrorCodeInResponse(t *testing.T) {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+			"vote": &graphql.Field{Type: graphql.Boolean, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return false, publicError(p.Context, control.ErrTicketExhausted)
+			}},
+		}}),
+	})
+	assert.NoError(t, err)
+	res := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ vote }", Context: context.Background()})
+	if assert.Len(t, res.Errors, 1) {
+		assert.Equal(t, CodeTicketExhausted, res.Errors[0].Extensions["code"])
+	}
+
+	schema, err = NewGraphQLSchema()
+	assert.NoError(t, err)
+	res = graphql.Do(graphql.Params{Schema: schema, RequestString: `{ __type(name: "ErrorCode") { enumValues { name } } }`, Context: context.Background()})
+	assert.Empty(t, res.Errors)
+	values := res.Data.(map[string]interface{})["__type"].(map[string]interface{})["enumValues"].([]interface{})
+	assert.Len(t, values, 12)
+}
@@ -0,0 +1,34 @@
+package graphql
+
+import (
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"VoteMe/tracing"
+	"github.com/graphql-go/graphql"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// instrument 包装解析函数，按字段和成功/失败记录耗时，并为解析函数创建 span
+func instrument(field string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		start := time.Now()
+		ctx, span := tracing.Start(params.Context, "graphql.resolve "+field, attribute.String("graphql.field", field))
+		params.Context = ctx
+		result, err := resolve(params)
+		tracing.End(span, err)
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		elapsed := time.Since(start)
+		metrics.ResolverDuration.WithLabelValues(field, status).Observe(elapsed.Seconds())
+		logging.FromContext(params.Context).WithFields(log.Fields{
+			"field":   field,
+			"status":  status,
+			"elapsed": elapsed,
+		}).Debug("graphql resolver finished")
+		return result, err
+	}
+}
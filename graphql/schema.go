@@ -1,10 +1,22 @@
 package graphql
 
 import (
+	"VoteMe/auth"
+	"VoteMe/challenge"
+	"VoteMe/config"
+	"VoteMe/contest"
 	"VoteMe/control"
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"VoteMe/tally"
+	"VoteMe/tracing"
 	"VoteMe/utils" // 导入utils包用于获取当前票据
+	"context"
+	"errors"
 	"fmt"
 	"github.com/graphql-go/graphql" // 导入graphql包用于创建GraphQL服务
+	"sort"
+	"time"
 )
 
 // 定义GraphQL中的用户类型
@@ -39,37 +51,143 @@ var ticketType = graphql.NewObject(
 	},
 )
 
+// 定义GraphQL中的工作量证明挑战类型
+// 客户端需要找到 nonce，使 sha256(challenge + ":" + nonce) 的前 difficulty 位为 0，再用它换取票据
+var challengeType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Challenge",
+		Fields: graphql.Fields{
+			"challenge":  &graphql.Field{Type: graphql.String}, // 挑战内容
+			"difficulty": &graphql.Field{Type: graphql.Int},    // 需要的前导零位数
+			"algorithm":  &graphql.Field{Type: graphql.String}, // 哈希算法，目前固定为 sha256
+			"expiresAt":  &graphql.Field{Type: graphql.String}, // 过期时间
+		},
+	},
+)
+
+// 定义GraphQL中的即时决选轮次类型
+var roundType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "RunoffRound",
+		Fields: graphql.Fields{
+			"round":      &graphql.Field{Type: graphql.Int},                     // 第几轮，从 1 开始
+			"counts":     &graphql.Field{Type: graphql.NewList(userType)},       // 仍在竞选中的选手本轮得票
+			"eliminated": &graphql.Field{Type: graphql.NewList(graphql.String)}, // 本轮被淘汰的选手
+			"exhausted":  &graphql.Field{Type: graphql.Int},                     // 排名中的选手已全部淘汰的选票数
+		},
+	},
+)
+
+// 定义GraphQL中的投票结果类型，按当前投票方式计算
+var resultsType = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "Results",
+		Fields: graphql.Fields{
+			"mode":    &graphql.Field{Type: graphql.String},                  // 投票方式
+			"winners": &graphql.Field{Type: graphql.NewList(graphql.String)}, // 当选者，并列时有多个
+			"totals":  &graphql.Field{Type: graphql.NewList(userType)},       // 已刷盘的票数，排序复选时为第一选择的票数
+			"rounds":  &graphql.Field{Type: graphql.NewList(roundType)},      // 即时决选的每一轮，只有排序复选才有
+			"ballots": &graphql.Field{Type: graphql.Int},                     // 排序复选参与计算的选票数
+		},
+	},
+)
+
 // 定义GraphQL查询类型
-// 这里定义了两个查询：getUserVotes和getCurrentTicket
+// 这里定义了四个查询：getUserVotes、getChallenge、getCurrentTicket和getResults
+// 运行时配置只能通过管理接口的 status 查看
 var queryType = graphql.NewObject(
 	graphql.ObjectConfig{
 		Name: "Query",
 		Fields: graphql.Fields{
 			"getUserVotes": &graphql.Field{
-				Type: graphql.Int, // 返回类型为整数，直接返回票数
+				Type:        graphql.Int, // 返回类型为整数，直接返回票数
+				Description: "Cached votes of a candidate. Errors: UNKNOWN_CANDIDATE, RATE_LIMITED, BACKEND_UNAVAILABLE.",
 				Args: graphql.FieldConfigArgument{ // 查询参数
 					"name": &graphql.ArgumentConfig{
 						Type: graphql.String, // 参数类型为字符串
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) { // 解析函数
+				Resolve: instrument("getUserVotes", func(params graphql.ResolveParams) (interface{}, error) { // 解析函数
 					name, _ := params.Args["name"].(string)
-					votes, err := control.GetVotesByName(name) // 获取name的票数，先去缓存查，没有再查数据库 600qps
+					if err := control.CheckCandidates([]string{name}); err != nil {
+						return nil, publicError(params.Context, err)
+					}
+					votes, err := control.GetVotesByName(params.Context, name) // 获取name的票数，先去缓存查，没有再查数据库 600qps
 					if err != nil {
-						return nil, fmt.Errorf("error getting votes for user %s: %s", name, err)
+						return nil, publicError(params.Context, fmt.Errorf("%w: get votes for user %s: %s", control.ErrBackendUnavailable, name, err))
 					}
 					return votes, nil
-				},
+				}),
+			},
+			"getChallenge": &graphql.Field{ // 获取工作量证明挑战，开启 challenge 后需要先解出挑战才能获取票据
+				Type:        challengeType,
+				Description: "Proof-of-work challenge for getCurrentTicket. Errors: INVALID_CHALLENGE when challenges are disabled, RATE_LIMITED, BACKEND_UNAVAILABLE.",
+				Resolve: instrument("getChallenge", func(params graphql.ResolveParams) (interface{}, error) {
+					c, err := challenge.Issue(params.Context)
+					if err != nil && !challenge.Rejected(err) {
+						err = fmt.Errorf("%w: issue challenge: %s", control.ErrBackendUnavailable, err)
+					}
+					if err != nil {
+						return nil, publicError(params.Context, err)
+					}
+					return map[string]interface{}{
+						"challenge":  c.ID,
+						"difficulty": c.Difficulty,
+						"algorithm":  "sha256",
+						"expiresAt":  c.ExpiresAt.Format(time.RFC3339),
+					}, nil
+				}),
 			},
 			"getCurrentTicket": &graphql.Field{ // 获取当前票据查询
 				Type: ticketType,
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				Description: "Current voting ticket. Errors: CONTEST_NOT_OPEN, INVALID_CHALLENGE, UNAUTHENTICATED, " +
+					"RATE_LIMITED, BACKEND_UNAVAILABLE.",
+				Args: graphql.FieldConfigArgument{ // 开启 challenge 时必须带上挑战及其答案
+					"challenge": &graphql.ArgumentConfig{Type: graphql.String},
+					"nonce":     &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: instrument("getCurrentTicket", func(params graphql.ResolveParams) (interface{}, error) {
+					// 活动开放之前和结束之后不发放票据
+					if err := requireOpen(params.Context); err != nil {
+						return nil, publicError(params.Context, err)
+					}
 					currentTicket := utils.GetCurrentTicket() // 获取当前票据 800qps
+					if config.Current().ChallengeEnabled {
+						id, _ := params.Args["challenge"].(string)
+						nonce, _ := params.Args["nonce"].(string)
+						if err := challenge.Verify(params.Context, id, nonce); err != nil && !challenge.Rejected(err) {
+							return nil, publicError(params.Context, fmt.Errorf("%w: verify challenge: %s", control.ErrBackendUnavailable, err))
+						} else if err != nil {
+							logging.FromContext(params.Context).WithError(err).Info("ticket request rejected: challenge not solved")
+							return nil, publicError(params.Context, err)
+						}
+						// 票据绑定解出的挑战，每个答案只能投 challenge.ticketUses 次票
+						currentTicket = challenge.BindTicket(currentTicket, id)
+					}
+					if auth.Enabled() {
+						// 开启认证后票据与投票人绑定，其他投票人拿到也无法使用
+						voter, err := auth.RequireVoter(params.Context)
+						if err != nil {
+							return nil, publicError(params.Context, err)
+						}
+						currentTicket = auth.BindTicket(currentTicket, voter)
+					}
 					return map[string]interface{}{
 						"ticketID": currentTicket,
 						"validity": true,
 					}, nil
-				},
+				}),
+			},
+			"getResults": &graphql.Field{ // 按投票方式计算结果，只统计已刷盘的投票
+				Type:        resultsType,
+				Description: "Results computed by the configured voting mode from flushed votes, or from saved ballots in ranked mode. Errors: RATE_LIMITED, BACKEND_UNAVAILABLE.",
+				Resolve: instrument("getResults", func(params graphql.ResolveParams) (interface{}, error) {
+					res, err := tally.Compute(params.Context, config.GetGlobalConf().VotingConfig)
+					if err != nil {
+						return nil, publicError(params.Context, fmt.Errorf("%w: compute results: %s", control.ErrBackendUnavailable, err))
+					}
+					return resultsResult(res), nil
+				}),
 			},
 		},
 	},
@@ -84,6 +202,8 @@ var mutationType = graphql.NewObject(
 		Fields: graphql.Fields{
 			"vote": &graphql.Field{
 				Type: graphql.Boolean, // 投票操作的返回类型为布尔值，表示是否成功
+				Description: "Cast a ballot with one ticket use. Errors: UNAUTHENTICATED, CONTEST_NOT_OPEN, INVALID_BALLOT, " +
+					"UNKNOWN_CANDIDATE, TICKET_EXPIRED, TICKET_UNKNOWN, TICKET_EXHAUSTED, INVALID_CHALLENGE, RATE_LIMITED, BACKEND_UNAVAILABLE. See the ErrorCode enum.",
 				Args: graphql.FieldConfigArgument{ // 变更参数
 					"name": &graphql.ArgumentConfig{
 						Type: graphql.NewList(graphql.String), // 支持输入多个用户名
@@ -92,12 +212,91 @@ var mutationType = graphql.NewObject(
 						Type: graphql.String, // 票据字段
 					},
 				},
-				Resolve: func(params graphql.ResolveParams) (interface{}, error) { // 解析函数
+				Resolve: instrument("vote", func(params graphql.ResolveParams) (interface{}, error) { // 解析函数
 					names, _ := params.Args["name"].([]interface{})
 					ticketID, _ := params.Args["ticket"].(string)
-					err := control.DecreaseUsageLimit(ticketID)
-					if err != nil {
-						return false, fmt.Errorf("invalid or expired ticket")
+					var voter string
+					if auth.Enabled() {
+						var err error
+						voter, err = auth.RequireVoter(params.Context)
+						if err == nil {
+							ticketID, err = auth.OpenTicket(ticketID, voter)
+						}
+						if err != nil {
+							logging.FromContext(params.Context).WithError(err).Info("vote rejected: unauthorized")
+							metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonUnauthorized).Inc()
+							return false, publicError(params.Context, err)
+						}
+					}
+					// 活动不在开放状态时直接拒绝，不消耗票据的使用次数
+					if err := requireOpen(params.Context); err != nil {
+						if errors.Is(err, contest.ErrNotOpen) {
+							metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonNotOpen).Inc()
+						} else {
+							metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonBackend).Inc()
+						}
+						return false, publicError(params.Context, err)
+					}
+					// 先检查选票，不合法的选票不消耗票据的使用次数
+					voting := config.GetGlobalConf().VotingConfig
+					ballot := make([]string, 0, len(names))
+					for i, nameInterface := range names {
+						name, ok := nameInterface.(string)
+						if !ok {
+							metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonInvalidName).Inc()
+							return false, &tally.RuleError{Rule: tally.RuleNameType, Message: "names must be strings",
+								Details: map[string]interface{}{"index": i}}
+						}
+						ballot = append(ballot, name)
+					}
+					if err := tally.Validate(voting, ballot); err != nil {
+						// 返回 *tally.RuleError，extensions.rule 说明违反了哪条规则
+						logging.FromContext(params.Context).WithError(err).Info("vote rejected: invalid ballot")
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonInvalidBallot).Inc()
+						return false, err
+					}
+					if err := control.CheckCandidates(ballot); err != nil {
+						logging.FromContext(params.Context).WithError(err).Info("vote rejected: unknown candidate")
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonInvalidName).Inc()
+						return false, publicError(params.Context, err)
+					}
+					// 开启 challenge 后票据必须绑定解出的挑战，先消耗该答案的使用次数，票据校验失败时再归还
+					ticketID, challengeID := challenge.OpenTicket(ticketID)
+					var err error
+					if challengeID != "" {
+						err = challenge.UseTicket(params.Context, challengeID)
+					} else if config.Current().ChallengeEnabled {
+						err = challenge.ErrUnboundTicket
+					}
+					switch {
+					case err == nil:
+					case challenge.Rejected(err):
+						logging.FromContext(params.Context).WithError(err).Info("vote rejected: invalid challenge ticket")
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonInvalidTicket).Inc()
+						return false, publicError(params.Context, err)
+					default:
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonBackend).Inc()
+						return false, publicError(params.Context, fmt.Errorf("%w: use challenge ticket: %s", control.ErrBackendUnavailable, err))
+					}
+					// 开启认证后每个投票人单独计数，一个投票人不能用完所有人共用的使用次数
+					if voter != "" {
+						err = control.DecreaseVoterUsage(params.Context, ticketID, voter, config.GetGlobalConf().AuthConfig.VoterUses)
+					} else {
+						err = control.DecreaseUsageLimit(params.Context, ticketID)
+					}
+					if err != nil && challengeID != "" {
+						// 票据本身无效时归还挑战的使用次数，例如票据刚好轮换，客户端不需要重新解挑战
+						if rerr := challenge.RestoreTicket(params.Context, challengeID); rerr != nil {
+							logging.FromContext(params.Context).WithError(rerr).Warn("restore challenge ticket use failed")
+						}
+					}
+					if errors.Is(err, control.ErrBackendUnavailable) {
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonBackend).Inc()
+						return false, publicError(params.Context, err)
+					} else if err != nil {
+						logging.FromContext(params.Context).WithError(err).Info("vote rejected: invalid ticket")
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonInvalidTicket).Inc()
+						return false, publicError(params.Context, err)
 					}
 					//// 检查票据是否还有效
 					//if ticketID != utils.GetCurrentTicket() {
@@ -108,33 +307,97 @@ var mutationType = graphql.NewObject(
 					//if err != nil {
 					//	return false, err
 					//}
-					// 对每个用户名执行投票操作
-					for _, nameInterface := range names {
-						name, ok := nameInterface.(string)
-						if !ok {
-							return false, fmt.Errorf("invalid name type")
+					// 票数由投票方式和选择的顺序决定
+					votes := make(map[string]int64, len(ballot))
+					for i, name := range ballot {
+						if n := tally.Weight(voting, i); n != 0 {
+							votes[name] += n
 						}
-						// 1：使用redis分布式锁，UpdateUserVotesWithLock
-						// 2：使用乐观锁，UpdateUserVotesWithRetry
-						err := control.VoteForUserRedis(name) // 增加redis中的库存数
-						if err != nil {
-							return false, err
+					}
+					if voting.Mode == config.VotingRanked {
+						// 排序复选的结果只按保存的选票计算，选票保存成功就已经计入
+						if err := tally.SaveBallot(params.Context, ballot); err != nil {
+							logging.FromContext(params.Context).WithError(err).Error("save ranked ballot failed")
+							metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonBackend).Inc()
+							return false, publicError(params.Context, fmt.Errorf("%w: save ballot: %s", control.ErrBackendUnavailable, err))
+						}
+						// redis 中的票数只用于 getUserVotes 展示第一选择的票数，失败时不影响结果
+						if err := control.AddBallotRedis(params.Context, votes); err != nil {
+							logging.FromContext(params.Context).WithError(err).Warn("ranked ballot saved but first choice votes were not added to redis")
 						}
+						metrics.Votes.WithLabelValues(metrics.VoteAccepted, "").Inc()
+						return true, nil
 					}
+					// 一张选票上的所有选手在一个事务中加票，不会只计入一部分
+					if err := control.AddBallotRedis(params.Context, votes); err != nil {
+						logging.FromContext(params.Context).WithError(err).Error("add ballot votes failed")
+						metrics.Votes.WithLabelValues(metrics.VoteRejected, metrics.ReasonBackend).Inc()
+						return false, publicError(params.Context, err)
+					}
+					metrics.Votes.WithLabelValues(metrics.VoteAccepted, "").Inc()
 					return true, nil // 如果所有操作成功，返回true
-				},
+				}),
 			},
 		},
 	},
 )
 
+// resultsResult 把计算结果转换为 Results 类型
+func resultsResult(res *tally.Results) map[string]interface{} {
+	totals := make([]map[string]interface{}, 0, len(res.Totals))
+	for _, t := range res.Totals {
+		totals = append(totals, map[string]interface{}{"name": t.Name, "votes": t.Votes})
+	}
+	rounds := make([]map[string]interface{}, 0, len(res.Rounds))
+	for i, r := range res.Rounds {
+		names := make([]string, 0, len(r.Counts))
+		for name := range r.Counts {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(a, b int) bool {
+			if r.Counts[names[a]] != r.Counts[names[b]] {
+				return r.Counts[names[a]] > r.Counts[names[b]]
+			}
+			return names[a] < names[b]
+		})
+		counts := make([]map[string]interface{}, 0, len(names))
+		for _, name := range names {
+			counts = append(counts, map[string]interface{}{"name": name, "votes": r.Counts[name]})
+		}
+		rounds = append(rounds, map[string]interface{}{
+			"round":      i + 1,
+			"counts":     counts,
+			"eliminated": r.Eliminated,
+			"exhausted":  r.Exhausted,
+		})
+	}
+	return map[string]interface{}{
+		"mode":    res.Mode,
+		"winners": res.Winners,
+		"totals":  totals,
+		"rounds":  rounds,
+		"ballots": res.Ballots,
+	}
+}
+
+// requireOpen 检查活动是否在开放状态，redis 出错时返回 ErrBackendUnavailable
+func requireOpen(ctx context.Context) error {
+	_, err := contest.RequireOpen(ctx)
+	if err != nil && !errors.Is(err, contest.ErrNotOpen) {
+		return fmt.Errorf("%w: check contest state: %v", control.ErrBackendUnavailable, err)
+	}
+	return err
+}
+
 // NewGraphQLSchema 创建新的GraphQL schema
 // 这个函数将上面定义的查询类型和变更类型组合成一个完整的schema
 func NewGraphQLSchema() (graphql.Schema, error) {
 	Schema, err := graphql.NewSchema(
 		graphql.SchemaConfig{
-			Query:    queryType,
-			Mutation: mutationType,
+			Query:      queryType,
+			Mutation:   mutationType,
+			Types:      []graphql.Type{errorCodeEnum},                   // 没有字段引用，需要显式加入才能被内省查到
+			Extensions: []graphql.Extension{tracing.GraphQLExtension{}}, // 解析、校验、执行阶段的 span
 		},
 	)
 	return Schema, err
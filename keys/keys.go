@@ -0,0 +1,146 @@
+package keys
+
+import (
+	"VoteMe/config"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultPrefix 未配置 key_prefix 时使用的命名空间
+const DefaultPrefix = "Voteme"
+
+var (
+	schema     Schema
+	schemaOnce sync.Once
+)
+
+// Schema 统一描述 voteme 在 redis 中使用的所有键
+// 所有键都挂在同一个前缀下，不同环境/租户配置不同前缀即可共用一个 redis
+type Schema struct {
+	prefix string
+}
+
+// New 使用指定前缀创建键模式，前缀为空时使用默认前缀
+func New(prefix string) Schema {
+	prefix = strings.TrimSuffix(prefix, ":")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return Schema{prefix: prefix}
+}
+
+// Prefix 返回当前命名空间前缀
+func (s Schema) Prefix() string {
+	return s.prefix
+}
+
+// Votes 选手在 redis 中尚未刷盘的增量票数
+func (s Schema) Votes(name string) string {
+	return s.join("votes", name)
+}
+
+// CurrentVotes 选手总票数的查询缓存
+func (s Schema) CurrentVotes(name string) string {
+	return s.join("current", "votes", name)
+}
+
+// Ticket 有效票据及其剩余使用次数
+func (s Schema) Ticket(ticketID string) string {
+	return s.join("ticketIDCache", ticketID)
+}
+
+// IssuedTicket 签发过的票据及其已使用的次数，保留时间比票据本身长，用于区分过期的票据和伪造的票据
+func (s Schema) IssuedTicket(ticketID string) string {
+	return s.join("ticketIssued", ticketID)
+}
+
+// VoterTicket 投票人使用绑定票据的次数，与票据同时过期
+func (s Schema) VoterTicket(ticketID, voter string) string {
+	return s.Ticket(ticketID) + ":" + voter
+}
+
+// SyncLock 刷盘锁，同一时间只有一个实例在刷盘
+func (s Schema) SyncLock() string {
+	return s.join("sync", "votes", "lock")
+}
+
+// VoteLock 投票时对单个选手加的分布式锁
+func (s Schema) VoteLock(name string) string {
+	return s.join("update", "user", "vote", "lock", name)
+}
+
+// CurrentVotesLock 票数缓存失效后，回源数据库时加的分布式锁
+func (s Schema) CurrentVotesLock(name string) string {
+	return s.join("get", "user", "vote", "lock", name)
+}
+
+// Challenge 工作量证明挑战，值为挑战的难度，使用一次后删除
+func (s Schema) Challenge(id string) string {
+	return s.join("challenge", id)
+}
+
+// ChallengeTicket 解出挑战后换到的票据剩余的使用次数，每个答案单独计数
+func (s Schema) ChallengeTicket(id string) string {
+	return s.join("challengeUses", id)
+}
+
+// Contest 投票活动的状态和计划时间的缓存，持久化的状态保存在 mysql 中
+func (s Schema) Contest() string {
+	return s.join("contest")
+}
+
+// RateLimit 限流计数器，按操作、维度（ip 或 ticket）、对象和时间窗口序号区分
+func (s Schema) RateLimit(operation, scope, subject string, window int64) string {
+	return s.join("ratelimit", operation, scope, subject, strconv.FormatInt(window, 10))
+}
+
+func (s Schema) join(parts ...string) string {
+	return s.prefix + ":" + strings.Join(parts, ":")
+}
+
+// Default 返回根据配置文件中 redis.key_prefix 创建的键模式
+func Default() Schema {
+	schemaOnce.Do(func() {
+		schema = New(config.GetGlobalConf().RedisConfig.KeyPrefix)
+	})
+	return schema
+}
+
+// Votes 见 Schema.Votes
+func Votes(name string) string { return Default().Votes(name) }
+
+// CurrentVotes 见 Schema.CurrentVotes
+func CurrentVotes(name string) string { return Default().CurrentVotes(name) }
+
+// Ticket 见 Schema.Ticket
+func Ticket(ticketID string) string { return Default().Ticket(ticketID) }
+
+// IssuedTicket 见 Schema.IssuedTicket
+func IssuedTicket(ticketID string) string { return Default().IssuedTicket(ticketID) }
+
+// VoterTicket 见 Schema.VoterTicket
+func VoterTicket(ticketID, voter string) string { return Default().VoterTicket(ticketID, voter) }
+
+// SyncLock 见 Schema.SyncLock
+func SyncLock() string { return Default().SyncLock() }
+
+// VoteLock 见 Schema.VoteLock
+func VoteLock(name string) string { return Default().VoteLock(name) }
+
+// CurrentVotesLock 见 Schema.CurrentVotesLock
+func CurrentVotesLock(name string) string { return Default().CurrentVotesLock(name) }
+
+// Challenge 见 Schema.Challenge
+func Challenge(id string) string { return Default().Challenge(id) }
+
+// ChallengeTicket 见 Schema.ChallengeTicket
+func ChallengeTicket(id string) string { return Default().ChallengeTicket(id) }
+
+// Contest 见 Schema.Contest
+func Contest() string { return Default().Contest() }
+
+// RateLimit 见 Schema.RateLimit
+func RateLimit(operation, scope, subject string, window int64) string {
+	return Default().RateLimit(operation, scope, subject, window)
+}
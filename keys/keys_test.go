@@ -0,0 +1,26 @@
+package keys
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 测试键格式与旧版本保持一致，并且不同前缀互不干扰
+func TestSchema(t *testing.T) {
+	s := New("")
+	assert.Equal(t, "Voteme:votes:Alice", s.Votes("Alice"))
+	assert.Equal(t, "Voteme:current:votes:Alice", s.CurrentVotes("Alice"))
+	assert.Equal(t, "Voteme:ticketIDCache:abc", s.Ticket("abc"))
+	assert.Equal(t, "Voteme:ticketIssued:abc", s.IssuedTicket("abc"))
+	assert.Equal(t, "Voteme:ticketIDCache:abc:alice", s.VoterTicket("abc", "alice"))
+	assert.Equal(t, "Voteme:update:user:vote:lock:Alice", s.VoteLock("Alice"))
+	assert.Equal(t, "Voteme:get:user:vote:lock:Alice", s.CurrentVotesLock("Alice"))
+	assert.Equal(t, "Voteme:challenge:c1", s.Challenge("c1"))
+	assert.Equal(t, "Voteme:challengeUses:c1", s.ChallengeTicket("c1"))
+	assert.Equal(t, "Voteme:sync:votes:lock", s.SyncLock())
+	assert.Equal(t, "Voteme:ratelimit:vote:ip:10.0.0.1:42", s.RateLimit("vote", "ip", "10.0.0.1", 42))
+
+	staging := New("Voteme:staging:")
+	assert.Equal(t, "Voteme:staging", staging.Prefix())
+	assert.Equal(t, "Voteme:staging:votes:Alice", staging.Votes("Alice"))
+}
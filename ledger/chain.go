@@ -0,0 +1,61 @@
+package ledger
+
+import (
+	"VoteMe/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// GenesisHash 第一条记录的 PrevHash
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// EntryHash 计算记录的哈希，覆盖序号、上一条的哈希和记录内容，时间精确到毫秒
+func EntryHash(e *model.LedgerEntry) string {
+	// 字段顺序固定，选手名按 JSON 转义，不会因为分隔符产生歧义
+	content, _ := json.Marshal(struct {
+		Seq       uint64 `json:"seq"`
+		PrevHash  string `json:"prev"`
+		Candidate string `json:"candidate"`
+		Delta     int64  `json:"delta"`
+		Votes     int64  `json:"votes"`
+		At        int64  `json:"at"`
+	}{e.Seq, e.PrevHash, e.Candidate, e.Delta, e.Votes, e.CreatedAt.UnixMilli()})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// MerkleRoot 按 RFC 6962 计算一组记录哈希的 Merkle 根，叶子和内部节点使用不同的前缀，避免第二原像攻击
+func MerkleRoot(hashes []string) (string, error) {
+	leaves := make([][]byte, len(hashes))
+	for i, h := range hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return "", fmt.Errorf("invalid hash %q: %w", h, err)
+		}
+		leaves[i] = b
+	}
+	return hex.EncodeToString(merkleTreeHash(leaves)), nil
+}
+
+func merkleTreeHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		sum := sha256.Sum256(append([]byte{0}, leaves[0]...))
+		return sum[:]
+	}
+	// 左子树取小于 n 的最大的 2 的幂个叶子
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	node := append([]byte{1}, merkleTreeHash(leaves[:k])...)
+	node = append(node, merkleTreeHash(leaves[k:])...)
+	sum := sha256.Sum256(node)
+	return sum[:]
+}
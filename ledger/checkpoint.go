@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"VoteMe/db"
+	"VoteMe/logging"
+	"VoteMe/model"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Checkpointer 每隔 interval 对新增的账本记录生成一个 Merkle 根检查点
+// 出错时返回错误由调用方决定是否重启，ctx 取消后正常退出
+func Checkpointer(interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil
+			}
+			if _, err := Checkpoint(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+// Checkpoint 对上一个检查点之后的所有记录生成检查点，没有新记录或其他实例已经生成时返回 nil
+func Checkpoint(ctx context.Context) (*model.LedgerCheckpoint, error) {
+	conn := db.GetDB().WithContext(ctx)
+	from := uint64(1)
+	var last model.LedgerCheckpoint
+	err := conn.Order("to_seq DESC").Take(&last).Error
+	if err == nil {
+		from = last.ToSeq + 1
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("load last checkpoint: %w", err)
+	}
+	var head model.LedgerHead
+	if err := conn.Take(&head, headID).Error; err != nil {
+		return nil, fmt.Errorf("load ledger head: %w", err)
+	}
+	if head.Seq < from {
+		return nil, nil
+	}
+
+	var hashes []string
+	err = conn.Model(&model.LedgerEntry{}).Where("seq BETWEEN ? AND ?", from, head.Seq).Order("seq").Pluck("hash", &hashes).Error
+	if err != nil {
+		return nil, fmt.Errorf("load ledger entries: %w", err)
+	}
+	if uint64(len(hashes)) != head.Seq-from+1 {
+		return nil, fmt.Errorf("ledger entries %d-%d are incomplete: found %d, run voteme verify-ledger", from, head.Seq, len(hashes))
+	}
+	root, err := MerkleRoot(hashes)
+	if err != nil {
+		return nil, err
+	}
+	cp := &model.LedgerCheckpoint{FromSeq: from, ToSeq: head.Seq, Root: root}
+	if err := conn.Create(cp).Error; err != nil {
+		// from_seq 上有唯一索引，其他实例已经生成了同一段的检查点
+		var count int64
+		if conn.Model(&model.LedgerCheckpoint{}).Where("from_seq = ?", from).Count(&count); count > 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("save checkpoint: %w", err)
+	}
+	// 日志会被收集到其他系统，相当于把 Merkle 根发布到了数据库之外
+	logging.FromContext(ctx).WithFields(log.Fields{"from_seq": cp.FromSeq, "to_seq": cp.ToSeq, "root": cp.Root}).
+		Info("ledger checkpoint created")
+	return cp, nil
+}
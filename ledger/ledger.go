@@ -0,0 +1,72 @@
+package ledger
+
+import (
+	"VoteMe/db"
+	"VoteMe/model"
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync/atomic"
+	"time"
+)
+
+// headID 账本头只有一行
+const headID = 1
+
+var enabled atomic.Bool
+
+// Setup 按配置开启账本
+func Setup(on bool) {
+	enabled.Store(on)
+}
+
+// Enabled 是否开启了账本，开启后刷盘通过 Record 写入
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Migrate 创建账本相关的表，并初始化账本头
+func Migrate(ctx context.Context) error {
+	conn := db.GetDB().WithContext(ctx)
+	if err := conn.AutoMigrate(&model.LedgerEntry{}, &model.LedgerHead{}, &model.LedgerCheckpoint{}); err != nil {
+		return err
+	}
+	return conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.LedgerHead{ID: headID, Hash: GenesisHash}).Error
+}
+
+// Record 在一个事务中执行 apply（把增量写入 users.votes）并追加一条账本记录
+// 追加前锁住账本头，多个实例同时刷盘时按顺序追加；apply 或追加失败时整个事务回滚
+func Record(ctx context.Context, candidate string, delta int64, apply func(tx *gorm.DB) error) (*model.LedgerEntry, error) {
+	var entry model.LedgerEntry
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var head model.LedgerHead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&head, headID).Error; err != nil {
+			return fmt.Errorf("lock ledger head: %w", err)
+		}
+		if err := apply(tx); err != nil {
+			return err
+		}
+		var votes int64
+		if err := tx.Model(&model.User{}).Select("votes").Where("name = ?", candidate).Scan(&votes).Error; err != nil {
+			return fmt.Errorf("read votes after update: %w", err)
+		}
+		entry = model.LedgerEntry{
+			Seq:       head.Seq + 1,
+			PrevHash:  head.Hash,
+			Candidate: candidate,
+			Delta:     delta,
+			Votes:     votes,
+			CreatedAt: time.Now().Truncate(time.Millisecond), // mysql 只保存到毫秒
+		}
+		entry.Hash = EntryHash(&entry)
+		if err := tx.Create(&entry).Error; err != nil {
+			return fmt.Errorf("append ledger entry: %w", err)
+		}
+		return tx.Model(&head).Updates(map[string]interface{}{"seq": entry.Seq, "hash": entry.Hash}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"VoteMe/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// chain 按顺序生成一段合法的账本记录
+func chain(deltas ...int64) []model.LedgerEntry {
+	var entries []model.LedgerEntry
+	prev, votes := GenesisHash, int64(0)
+	for i, d := range deltas {
+		votes += d
+		e := model.LedgerEntry{Seq: uint64(i + 1), PrevHash: prev, Candidate: "alice", Delta: d, Votes: votes,
+			CreatedAt: time.UnixMilli(1714560000000 + int64(i))}
+		e.Hash = EntryHash(&e)
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func walk(entries []model.LedgerEntry, checkpoints ...model.LedgerCheckpoint) *Break {
+	v := &verifier{report: &Report{}, prevHash: GenesisHash, votes: map[string]int64{}, checkpoints: checkpoints}
+	if b := v.checkRanges(); b != nil {
+		return b
+	}
+	for i := range entries {
+		if b := v.entry(&entries[i]); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+func TestVerifyChain(t *testing.T) {
+	entries := chain(3, 5, 2, 7)
+	root, err := MerkleRoot([]string{entries[0].Hash, entries[1].Hash, entries[2].Hash})
+	assert.NoError(t, err)
+	cp := model.LedgerCheckpoint{ID: 1, FromSeq: 1, ToSeq: 3, Root: root}
+	assert.Nil(t, walk(entries, cp))
+
+	modified := chain(3, 5, 2, 7)
+	modified[1].Delta = 6
+	assert.Equal(t, uint64(2), walk(modified).Seq, "修改了记录内容")
+
+	rehashed := chain(3, 5, 2, 7)
+	rehashed[1].Votes, rehashed[1].Hash = 9, ""
+	rehashed[1].Hash = EntryHash(&rehashed[1])
+	assert.Equal(t, uint64(2), walk(rehashed).Seq, "重新计算了哈希，但票数对不上")
+
+	deleted := append(chain(3, 5, 2, 7)[:1], chain(3, 5, 2, 7)[2:]...)
+	assert.Equal(t, uint64(2), walk(deleted).Seq, "删除了记录")
+
+	badRoot := cp
+	badRoot.Root = GenesisHash
+	assert.Equal(t, uint64(1), walk(entries, badRoot).Seq, "检查点不匹配")
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := GenesisHash, EntryHash(&model.LedgerEntry{Seq: 1}), EntryHash(&model.LedgerEntry{Seq: 2})
+	leaf := func(h string) []byte {
+		raw, _ := hex.DecodeString(h)
+		sum := sha256.Sum256(append([]byte{0}, raw...))
+		return sum[:]
+	}
+	node := func(l, r []byte) []byte {
+		sum := sha256.Sum256(append(append([]byte{1}, l...), r...))
+		return sum[:]
+	}
+	root, err := MerkleRoot([]string{a, b, c})
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(node(node(leaf(a), leaf(b)), leaf(c))), root)
+
+	swapped, _ := MerkleRoot([]string{b, a, c})
+	assert.NotEqual(t, root, swapped)
+}
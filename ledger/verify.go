@@ -0,0 +1,158 @@
+package ledger
+
+import (
+	"VoteMe/db"
+	"VoteMe/model"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// verifyBatch 每次从数据库读取的记录数
+const verifyBatch = 1000
+
+// Break 账本中第一处断开的位置
+type Break struct {
+	Seq    uint64 // 出问题的记录序号，与记录无关时为 0
+	Reason string
+}
+
+func (b *Break) Error() string {
+	if b.Seq == 0 {
+		return b.Reason
+	}
+	return fmt.Sprintf("entry %d: %s", b.Seq, b.Reason)
+}
+
+// Report 校验结果
+type Report struct {
+	Entries     uint64 // 校验通过的记录数
+	Checkpoints int    // 校验通过的检查点数
+	Broken      *Break // 第一处断开的位置，账本完整时为 nil
+}
+
+// Verify 在一个只读快照中按顺序遍历账本，检查序号连续、哈希链、每个选手的票数累加、检查点的 Merkle 根，
+// 最后核对账本头和 users.votes，遇到第一处断开时停止
+func Verify(ctx context.Context) (*Report, error) {
+	report := &Report{}
+	err := db.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		v := &verifier{tx: tx, report: report, prevHash: GenesisHash, votes: map[string]int64{}}
+		report.Broken = v.run()
+		return v.err
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+type verifier struct {
+	tx     *gorm.DB
+	report *Report
+	err    error // 数据库错误，与账本是否完整无关
+
+	prevSeq     uint64
+	prevHash    string
+	votes       map[string]int64 // 每个选手最近一条记录中的票数
+	checkpoints []model.LedgerCheckpoint
+	pending     []string // 当前检查点范围内的记录哈希
+}
+
+func (v *verifier) run() *Break {
+	if v.err = v.tx.Order("from_seq").Find(&v.checkpoints).Error; v.err != nil {
+		return nil
+	}
+	if b := v.checkRanges(); b != nil {
+		return b
+	}
+	for {
+		var entries []model.LedgerEntry
+		v.err = v.tx.Where("seq > ?", v.prevSeq).Order("seq").Limit(verifyBatch).Find(&entries).Error
+		if v.err != nil {
+			return nil
+		}
+		for i := range entries {
+			if b := v.entry(&entries[i]); b != nil {
+				return b
+			}
+		}
+		if len(entries) < verifyBatch {
+			break
+		}
+	}
+	return v.finish()
+}
+
+// checkRanges 检查点必须从 1 开始且首尾相接
+func (v *verifier) checkRanges() *Break {
+	next := uint64(1)
+	for _, cp := range v.checkpoints {
+		if cp.FromSeq != next || cp.ToSeq < cp.FromSeq {
+			return &Break{Seq: cp.FromSeq, Reason: fmt.Sprintf("checkpoint %d covers %d-%d, expected it to start at %d", cp.ID, cp.FromSeq, cp.ToSeq, next)}
+		}
+		next = cp.ToSeq + 1
+	}
+	return nil
+}
+
+func (v *verifier) entry(e *model.LedgerEntry) *Break {
+	if e.Seq != v.prevSeq+1 {
+		return &Break{Seq: v.prevSeq + 1, Reason: fmt.Sprintf("entry is missing, next entry is %d", e.Seq)}
+	}
+	if e.PrevHash != v.prevHash {
+		return &Break{Seq: e.Seq, Reason: "prev_hash does not match the previous entry"}
+	}
+	if EntryHash(e) != e.Hash {
+		return &Break{Seq: e.Seq, Reason: "hash does not match the entry content, entry was modified"}
+	}
+	if last, ok := v.votes[e.Candidate]; ok && last+e.Delta != e.Votes {
+		return &Break{Seq: e.Seq, Reason: fmt.Sprintf("votes of %s is %d, expected %d + %d", e.Candidate, e.Votes, last, e.Delta)}
+	}
+	v.votes[e.Candidate] = e.Votes
+	v.prevSeq, v.prevHash = e.Seq, e.Hash
+	v.report.Entries++
+
+	if v.report.Checkpoints < len(v.checkpoints) {
+		cp := v.checkpoints[v.report.Checkpoints]
+		v.pending = append(v.pending, e.Hash)
+		if e.Seq == cp.ToSeq {
+			root, err := MerkleRoot(v.pending)
+			if err != nil || root != cp.Root {
+				return &Break{Seq: cp.FromSeq, Reason: fmt.Sprintf("merkle root of checkpoint %d (%d-%d) does not match", cp.ID, cp.FromSeq, cp.ToSeq)}
+			}
+			v.report.Checkpoints++
+			v.pending = v.pending[:0]
+		}
+	}
+	return nil
+}
+
+// finish 检查末尾是否被截断，以及 users.votes 是否在刷盘之外被修改
+func (v *verifier) finish() *Break {
+	var head model.LedgerHead
+	if err := v.tx.Take(&head, headID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Break{Reason: "ledger head is missing"}
+	} else if err != nil {
+		v.err = err
+		return nil
+	}
+	if head.Seq != v.prevSeq || head.Hash != v.prevHash {
+		return &Break{Seq: v.prevSeq + 1, Reason: fmt.Sprintf("ledger head is at %d but the chain ends at %d, entries were deleted", head.Seq, v.prevSeq)}
+	}
+	if v.report.Checkpoints < len(v.checkpoints) {
+		cp := v.checkpoints[v.report.Checkpoints]
+		return &Break{Seq: cp.FromSeq, Reason: fmt.Sprintf("checkpoint %d covers %d-%d but the chain ends at %d", cp.ID, cp.FromSeq, cp.ToSeq, v.prevSeq)}
+	}
+	var users []model.User
+	if v.err = v.tx.Select("name", "votes").Find(&users).Error; v.err != nil {
+		return nil
+	}
+	for _, u := range users {
+		if expected, ok := v.votes[u.Name]; ok && int64(u.Votes) != expected {
+			return &Break{Reason: fmt.Sprintf("users.votes of %s is %d but the ledger ends at %d, votes were changed outside of flushes", u.Name, u.Votes, expected)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"VoteMe/config"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+)
+
+// RequestIDHeader 请求 ID 的请求头/响应头，上游网关传入时沿用，否则自动生成
+const RequestIDHeader = "X-Request-ID"
+
+type ctxKey int
+
+const (
+	entryKey ctxKey = iota
+	requestIDKey
+)
+
+// Setup 按配置设置全局日志级别和格式，所有包统一使用 logrus 的标准 logger
+func Setup(conf config.LogConf) error {
+	level, err := log.ParseLevel(conf.Level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	log.SetOutput(os.Stdout)
+	switch conf.Format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text", "":
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("unknown log format %q", conf.Format)
+	}
+	return nil
+}
+
+// FromContext 返回携带请求 ID 等字段的日志对象，ctx 中没有时返回标准 logger
+func FromContext(ctx context.Context) *log.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(entryKey).(*log.Entry); ok {
+			return entry
+		}
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// WithFields 在 ctx 的日志对象上追加字段，之后通过 FromContext 取到的日志都会带上这些字段
+func WithFields(ctx context.Context, fields log.Fields) context.Context {
+	return context.WithValue(ctx, entryKey, FromContext(ctx).WithFields(fields))
+}
+
+// WithRequestID 在 ctx 中记录请求 ID，并追加到日志字段中
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+	return WithFields(ctx, log.Fields{"request_id": id})
+}
+
+// RequestID 返回 ctx 中的请求 ID
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID 生成一个随机的请求 ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware 为每个请求生成请求 ID，写入响应头并放入请求的 ctx，GraphQL 解析函数通过 params.Context 取到
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
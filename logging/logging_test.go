@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 测试请求 ID 会写入响应头，并且解析函数能从 ctx 中取到同一个 ID
+func TestMiddlewareRequestID(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		assert.Equal(t, got, FromContext(r.Context()).Data["request_id"])
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", nil))
+	assert.NotEmpty(t, got)
+	assert.Equal(t, got, rec.Header().Get(RequestIDHeader))
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", nil)
+	req.Header.Set(RequestIDHeader, "from-gateway")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "from-gateway", got)
+}
@@ -1,51 +1,59 @@
 package main
 
 import (
-	"VoteMe/graphql"                // 导入自定义的graphql包，其中定义了GraphQL的schema，注意替换为实际的导入路径
-	"github.com/graphql-go/handler" // 导入graphql-go/handler包，用于处理GraphQL请求
-	"log"                           // 导入log包，用于记录日志
-	"net/http"                      // 导入net/http包，用于HTTP服务器的功能
-	_ "net/http/pprof"
-	"runtime"
+	"VoteMe/app"
+	"VoteMe/config"
+	"VoteMe/logging"
+	"context"
+	log "github.com/sirupsen/logrus" // 导入log包，用于记录日志
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	// pprof
-	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
-	//go func() {
-	//	for {
-	//		fmt.Println("...................最大票据数：", config.TicketLen)
-	//		time.Sleep(time.Second)
-	//	}
-	//}()
-	// 初始化全局配置
-	// 定义GraphQL服务可用的查询和变更操作
-	schema, err := graphql.NewGraphQLSchema()
-	if err != nil {
-		// 记录错误日志并终止程序
-		log.Fatalf("failed to create new schema, error: %v", err)
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfigCommand(os.Args[2:]))
+		case "verify-results":
+			os.Exit(runVerifyResultsCommand(os.Args[2:]))
+		case "verify-ledger":
+			os.Exit(runVerifyLedgerCommand(os.Args[2:]))
+		}
 	}
-	go func() {
-		runtime.SetBlockProfileRate(1)     // 开启对阻塞操作的跟踪，block
-		runtime.SetMutexProfileFraction(1) // 开启对锁调用的跟踪，mutex
-		http.ListenAndServe(":6060", nil)
-		log.Println("pprof is running on port 6060")
-	}()
-
-	// handler会解析请求，执行对应的GraphQL操作，并返回结果
-	h := handler.New(&handler.Config{
-		Schema: &schema, // 设置handler使用的GraphQL schema
-		Pretty: true,    // 设置返回的JSON数据格式化，便于阅读
-	})
 
-	http.Handle("/graphql", h)
+	// 解析命令行参数，优先级高于环境变量和配置文件
+	if err := config.ParseFlags(os.Args[1:]); err != nil {
+		log.Fatalf("failed to parse flags, error: %v", err)
+	}
+	if err := config.Load(); err != nil {
+		log.Fatalf("failed to load config, error: %v", err)
+	}
+	if err := logging.Setup(config.GetGlobalConf().LogConfig); err != nil {
+		log.Fatalf("failed to setup logger, error: %v", err)
+	}
 
-	// 输出日志，表示服务正在运行
-	log.Println("Now server is running on port 9090")
+	a := app.New()
+	if err := a.Start(context.Background()); err != nil {
+		// 记录错误日志并终止程序
+		log.Fatalf("failed to start voteme, error: %v", err)
+	}
 
-	// 使用http.ListenAndServe函数在8080端口启动HTTP服务器
-	log.Fatal(http.ListenAndServe(":9090", nil))
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-quit:
+	case err := <-a.Err():
+		log.Printf("server exited unexpectedly: %v", err)
+	}
 
+	// 优雅关闭的最长等待时间
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetGlobalConf().AppConfig.ShutdownTimeout)
+	defer cancel()
+	if err := a.Stop(ctx); err != nil {
+		log.Fatalf("shutdown voteme failed: %v", err)
+	}
+	log.Println("Server exited")
 }
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const namespace = "voteme"
+
+// 投票结果
+const (
+	VoteAccepted = "accepted"
+	VoteRejected = "rejected"
+)
+
+// 投票被拒绝的原因
+const (
+	ReasonInvalidTicket = "invalid_ticket" // 票据无效、过期或使用次数已达上限
+	ReasonInvalidName   = "invalid_name"   // 选手名不合法
+	ReasonBackend       = "backend_error"  // redis 等依赖出错
+	ReasonUnauthorized  = "unauthorized"   // 开启认证后没有携带 JWT，或者票据不是签发给当前投票人的
+	ReasonNotOpen       = "not_open"       // 投票活动还没开始、已暂停或已结束
+	ReasonInvalidBallot = "invalid_ballot" // 选票不符合投票方式的要求，例如单选时选了多个
+)
+
+var (
+	// Votes 投票请求数，按结果和拒绝原因区分
+	Votes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_total",
+		Help:      "Vote requests by result and rejection reason.",
+	}, []string{"result", "reason"})
+
+	// TicketRotations 票据换发次数
+	TicketRotations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ticket_rotations_total",
+		Help:      "Number of tickets issued by this instance.",
+	})
+
+	// TicketChecks 投票时校验票据的结果，unknown 突增说明有人在猜测票据
+	TicketChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ticket_checks_total",
+		Help:      "Ticket checks on vote by result (ok, expired, unknown, exhausted or voter_exhausted).",
+	}, []string{"result"})
+
+	// TicketRemainingUses 当前票据剩余的使用次数，每次扣减后更新
+	TicketRemainingUses = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ticket_remaining_uses",
+		Help:      "Remaining uses of the most recently used ticket.",
+	})
+
+	// CacheRequests 票数缓存命中情况
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by cache name and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	// SyncDuration 每次刷盘的耗时
+	SyncDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_votes_duration_seconds",
+		Help:      "Duration of flushing cached votes from redis to mysql.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// SyncErrors 刷盘失败次数，按失败的步骤区分
+	SyncErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_votes_errors_total",
+		Help:      "Errors while flushing votes, by failed step.",
+	}, []string{"step"})
+
+	// SyncPendingDelta 最近一次刷盘时 redis 中待刷入 mysql 的票数
+	SyncPendingDelta = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_votes_pending_delta",
+		Help:      "Votes pending in redis at the last flush.",
+	})
+
+	// Challenges 工作量证明挑战的发放和校验结果
+	Challenges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "challenges_total",
+		Help:      "Proof-of-work challenges by result (issued, solved, invalid, insufficient, used, exhausted).",
+	}, []string{"result"})
+
+	// RateLimited 被限流拒绝的请求数，按操作和限流维度（ip 或 ticket）区分
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter, by operation and scope.",
+	}, []string{"operation", "scope"})
+
+	// ContestState 投票活动当前所处的状态，当前状态为 1，其余为 0
+	ContestState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "contest_state",
+		Help:      "Current contest state (1 for the active state).",
+	}, []string{"state"})
+
+	// AdminActions 管理接口的操作次数，按操作和结果（ok、error、denied）区分
+	AdminActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "admin_actions_total",
+		Help:      "Admin API actions by action and result.",
+	}, []string{"action", "result"})
+
+	// ResolverDuration GraphQL 解析函数的耗时
+	ResolverDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "graphql_resolver_duration_seconds",
+		Help:      "Latency of GraphQL resolvers by field and status.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"field", "status"})
+
+	lastSync atomic.Int64 // 最近一次成功刷盘的时间，unix 纳秒
+)
+
+func init() {
+	// 距离最近一次成功刷盘过去了多久，持续增长说明刷盘卡住了
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_votes_lag_seconds",
+		Help:      "Seconds since the last successful flush.",
+	}, func() float64 {
+		return SyncLag().Seconds()
+	})
+}
+
+// MarkSynced 记录一次成功的刷盘
+func MarkSynced(t time.Time) {
+	lastSync.Store(t.UnixNano())
+}
+
+// LastSync 最近一次成功刷盘的时间，从未刷盘时返回零值
+func LastSync() time.Time {
+	last := lastSync.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// SyncLag 距离最近一次成功刷盘的时间，从未刷盘时返回 0
+func SyncLag() time.Duration {
+	last := lastSync.Load()
+	if last == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+// Handler 暴露 /metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// AuditLog 管理接口的操作记录，每次变更操作（包括被拒绝的）写入一条
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Actor      string    `gorm:"size:255;index"` // 操作人，JWT 中的 subject
+	Role       string    `gorm:"size:16"`        // 操作人的角色
+	Action     string    `gorm:"size:64;index"`  // 操作名，即管理接口的字段名
+	Args       string    `gorm:"type:text"`      // 操作参数，JSON
+	Result     string    `gorm:"size:16"`        // ok、error 或 denied
+	Error      string    `gorm:"type:text"`      // 失败原因
+	RemoteAddr string    `gorm:"size:64"`        // 请求来源地址
+	RequestID  string    `gorm:"size:64"`        // 对应日志中的 request_id
+}
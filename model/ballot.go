@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// Ballot 排序复选的选票，即时决选需要完整的排名，不能只累加票数
+type Ballot struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Ranking   string    `gorm:"type:text"` // 按排名排列的选手名，JSON 数组
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Certification 投票结束后认证的最终结果，每个活动只有一条，写入后不再修改
+// Payload 是签名时使用的规范化 JSON，Hash 为其 sha256，Signature 为对 Hash 的 Ed25519 签名
+type Certification struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	Contest     string            `gorm:"size:255;uniqueIndex"` // 活动标识，即 redis 键前缀
+	CertifiedBy string            `gorm:"size:255"`
+	Payload     string            `gorm:"type:mediumtext"`
+	Hash        string            `gorm:"size:64"`  // 十六进制
+	Signature   string            `gorm:"size:128"` // base64
+	PublicKey   string            `gorm:"size:64"`  // base64，签名时使用的公钥
+	Results     []CertifiedResult // 每个选手的票数快照
+}
+
+// CertifiedResult 认证时每个选手的票数快照，与 Certification 一起写入，写入后不再修改
+type CertifiedResult struct {
+	ID              uint   `gorm:"primarykey"`
+	CertificationID uint   `gorm:"uniqueIndex:idx_certification_candidate"`
+	Candidate       string `gorm:"size:255;uniqueIndex:idx_certification_candidate"`
+	Votes           int64
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// Contest 投票活动的状态，mysql 中的记录是持久化的状态，redis 中的 hash 只是缓存
+// 计划时间为空表示需要手动开放或结束，Version 为乐观锁版本号，每次修改加一
+type Contest struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"size:255;uniqueIndex"` // 活动标识，即 redis 键前缀
+	State     string `gorm:"size:32"`
+	Reason    string `gorm:"size:1024"`
+	StartsAt  *time.Time
+	EndsAt    *time.Time
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false"` // 由状态变更写入，不使用 gorm 的自动更新时间
+	UpdatedBy string    `gorm:"size:255"`
+	Version   int64
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// LedgerEntry 刷盘账本中的一条记录，对应一次把某个选手的增量写入 users.votes
+// Hash 由上一条记录的 Hash 和本条内容计算，任何一条被修改、删除或插入都会使之后的链断开
+type LedgerEntry struct {
+	Seq       uint64    `gorm:"primaryKey;autoIncrement:false"` // 从 1 开始连续递增
+	PrevHash  string    `gorm:"size:64"`                        // 上一条记录的 Hash，第一条为 64 个 0
+	Hash      string    `gorm:"size:64"`
+	Candidate string    `gorm:"size:255;index"`
+	Delta     int64     // 本次刷入的票数
+	Votes     int64     // 刷入后 users.votes 的值
+	CreatedAt time.Time `gorm:"precision:3"`
+}
+
+// LedgerHead 账本的最新位置，只有一行，追加记录时加行锁保证多个实例按顺序追加
+type LedgerHead struct {
+	ID   uint   `gorm:"primaryKey"`
+	Seq  uint64 // 最新记录的 Seq，没有记录时为 0
+	Hash string `gorm:"size:64"`
+}
+
+// LedgerCheckpoint 对一段连续记录的 Hash 计算的 Merkle 根，可以发布到外部用于事后比对
+type LedgerCheckpoint struct {
+	ID        uint   `gorm:"primaryKey"`
+	FromSeq   uint64 `gorm:"uniqueIndex"` // 多个实例同时生成时只有一个能写入
+	ToSeq     uint64
+	Root      string `gorm:"size:64"`
+	CreatedAt time.Time
+}
@@ -5,10 +5,15 @@ import (
 	"time"
 )
 
-// Ticket 在db_manager.go中添加Ticket结构体
+// Ticket 签发过的票据，使用次数在票据过期后从 redis 复制过来
 type Ticket struct {
 	gorm.Model
 	TicketID  string `gorm:"uniqueIndex"`
 	Uses      int    `gorm:"default:0"`
 	CreatedAt time.Time
+	MaxUses   int       // 签发时的最大使用次数
+	IssuedAt  time.Time `gorm:"index"`          // 签发时间
+	ExpiredAt time.Time `gorm:"index"`          // 过期时间，即签发时间加上票据更新时间
+	Instance  string    `gorm:"size:255;index"` // 签发票据的实例
+	Finalized bool      `gorm:"index"`          // Uses 是否已经是最终的使用次数
 }
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"VoteMe/config"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// proxyCache 按配置版本缓存解析后的可信代理，避免每个请求都解析一次
+type proxyCache struct {
+	version int64
+	nets    []*net.IPNet
+}
+
+var proxies atomic.Pointer[proxyCache]
+
+func trustedProxies(s *config.Settings) []*net.IPNet {
+	if c := proxies.Load(); c != nil && c.version == s.Version {
+		return c.nets
+	}
+	nets, err := config.ParseTrustedProxies(s.RateLimit.TrustedProxies)
+	if err != nil {
+		// 配置校验时已经检查过，这里不会发生，保守起见不信任任何代理
+		log.WithError(err).Error("parse trusted proxies failed")
+		nets = nil
+	}
+	proxies.Store(&proxyCache{version: s.Version, nets: nets})
+	return nets
+}
+
+// clientIP 返回客户端 IP：请求直接来自客户端时使用连接的对端地址；
+// 来自可信代理时从右往左查看 X-Forwarded-For，第一个不是可信代理的地址就是客户端
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !isTrusted(remote, trusted) {
+		return remote
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			// 格式不对说明这一跳是伪造的，不再往前看
+			return remote
+		}
+		if !isTrusted(hops[i], trusted) {
+			return hops[i]
+		}
+		remote = hops[i]
+	}
+	return remote
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRedisTimeout 限流检查使用与其他 redis 操作相同的超时时间
+func withRedisTimeout(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), config.Current().RedisTimeout)
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"VoteMe/db"
+	"VoteMe/keys"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// Quota 一个操作在一个限流维度（ip 或 ticket）上的配额
+type Quota struct {
+	Operation string
+	Scope     string
+	Subject   string
+	Limit     int
+	Window    time.Duration
+}
+
+// slidingWindow 滑动窗口计数：用当前窗口的计数加上上一个窗口按剩余比例折算的计数估算最近一个窗口内的请求数
+// 每个配额占两个键（当前窗口和上一个窗口）和三个参数（上限、窗口毫秒数、当前窗口已过去的毫秒数）。
+// 先检查所有配额，全部未超限时才给每个配额计数加一并返回 {0, 0}；
+// 任何一个超限时都不计数，返回 {建议的重试等待毫秒数, 超限的配额序号（从 1 开始）}。
+// 同一个键在一次请求中出现多次时（例如别名调用同一个操作）按出现的次数累计检查
+var slidingWindow = redis.NewScript(`
+local pending = {}
+local windows = {}
+for i = 1, #KEYS / 2 do
+  local curKey, prevKey = KEYS[2 * i - 1], KEYS[2 * i]
+  local limit = tonumber(ARGV[3 * i - 2])
+  local window = tonumber(ARGV[3 * i - 1])
+  local elapsed = tonumber(ARGV[3 * i])
+  local cur = tonumber(redis.call('GET', curKey) or '0') + (pending[curKey] or 0)
+  local prev = tonumber(redis.call('GET', prevKey) or '0')
+  local weight = (window - elapsed) / window
+  if prev * weight + cur + 1 > limit then
+    if cur + 1 > limit or prev == 0 then
+      return {window - elapsed, i}
+    end
+    local wait = (window - elapsed) - (limit - cur - 1) * window / prev
+    return {math.max(1, math.ceil(wait)), i}
+  end
+  pending[curKey] = (pending[curKey] or 0) + 1
+  windows[curKey] = window
+end
+for key, n in pairs(pending) do
+  redis.call('INCRBY', key, n)
+  redis.call('PEXPIRE', key, windows[key] * 2)
+end
+return {0, 0}
+`)
+
+// Allow 判断请求在所有配额上是否都还有余量，都有余量时每个配额计数加一并返回 0 和 -1；
+// 任何一个配额超限时都不计数，返回建议的重试等待时间和超限的配额在 quotas 中的下标
+func Allow(ctx context.Context, quotas []Quota) (time.Duration, int, error) {
+	if len(quotas) == 0 {
+		return 0, -1, nil
+	}
+	nowMs := time.Now().UnixMilli()
+	redisKeys := make([]string, 0, 2*len(quotas))
+	args := make([]interface{}, 0, 3*len(quotas))
+	for _, q := range quotas {
+		windowMs := q.Window.Milliseconds()
+		idx := nowMs / windowMs
+		redisKeys = append(redisKeys,
+			keys.RateLimit(q.Operation, q.Scope, q.Subject, idx),
+			keys.RateLimit(q.Operation, q.Scope, q.Subject, idx-1))
+		args = append(args, q.Limit, windowMs, nowMs%windowMs)
+	}
+
+	res, err := slidingWindow.Run(ctx, db.GetRedisCLi(), redisKeys, args...).Int64Slice()
+	if err != nil {
+		return 0, -1, err
+	}
+	if len(res) != 2 || res[0] <= 0 {
+		return 0, -1, nil
+	}
+	return time.Duration(res[0]) * time.Millisecond, int(res[1]) - 1, nil
+}
@@ -0,0 +1,220 @@
+package ratelimit
+
+import (
+	"VoteMe/config"
+	"VoteMe/logging"
+	"VoteMe/metrics"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/handler"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxBodyBytes 请求体的上限，超过时直接返回 413，不交给后面的 handler
+const maxBodyBytes = 1 << 20
+
+// errBodyTooLarge 请求体超过 maxBodyBytes
+var errBodyTooLarge = errors.New("request body too large")
+
+// call 请求中的一次顶层字段调用，同一个字段用别名调用多次时每次都计数
+type call struct {
+	operation string
+	ticket    string // ticket 参数，没有时为空
+}
+
+// Middleware 在认证和 GraphQL handler 之前按操作限流，同时按客户端 IP 和票据计数，
+// 请求中的所有调用在所有维度上都有余量时才计数，被拒绝的请求不消耗任何配额；
+// 超限时返回 429 和 GraphQL 格式的错误，extensions.retryAfter 为建议的重试秒数；请求体超过 maxBodyBytes 时返回 413。
+// redis 出错时放行，限流不可用不影响投票
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := config.Current()
+		if !settings.RateLimit.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		calls, err := parseCalls(r)
+		if errors.Is(err, errBodyTooLarge) {
+			// 不能解析出调用就无法限流，超长的请求直接拒绝
+			writeTooLarge(w)
+			return
+		}
+		ip := clientIP(r, trustedProxies(settings))
+		if err != nil {
+			// 解析失败时不知道会执行哪些操作，按所有操作的 IP 配额各计一次，再交给 handler 返回 GraphQL 的错误信息
+			calls = calls[:0]
+			for _, op := range config.RateLimitOperations {
+				calls = append(calls, call{operation: op})
+			}
+		}
+		if len(calls) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var quotas []Quota
+		for _, c := range calls {
+			rule, ok := settings.RateLimit.Rule(c.operation)
+			if !ok {
+				continue
+			}
+			if rule.PerIP > 0 {
+				quotas = append(quotas, Quota{Operation: c.operation, Scope: "ip", Subject: ip, Limit: rule.PerIP, Window: rule.Window})
+			}
+			if c.ticket != "" && rule.PerTicket > 0 {
+				quotas = append(quotas, Quota{Operation: c.operation, Scope: "ticket", Subject: c.ticket, Limit: rule.PerTicket, Window: rule.Window})
+			}
+		}
+		if wait, q := check(r, quotas); wait > 0 {
+			writeLimited(w, q.Operation, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check 返回需要等待的时间和超限的配额，0 表示放行
+func check(r *http.Request, quotas []Quota) (time.Duration, Quota) {
+	if len(quotas) == 0 {
+		return 0, Quota{}
+	}
+	ctx, cancel := withRedisTimeout(r)
+	defer cancel()
+	wait, i, err := Allow(ctx, quotas)
+	if err != nil {
+		logging.FromContext(r.Context()).WithError(err).Warn("rate limit check failed, allowing request")
+		return 0, Quota{}
+	}
+	if wait <= 0 {
+		return 0, Quota{}
+	}
+	q := quotas[i]
+	metrics.RateLimited.WithLabelValues(q.Operation, q.Scope).Inc()
+	logging.FromContext(r.Context()).WithField("scope", q.Scope).Infof("%s rate limited", q.Operation)
+	return wait, q
+}
+
+// parseCalls 读取请求中的 GraphQL 语句，找出本次要执行的操作中所有顶层字段调用
+func parseCalls(r *http.Request) ([]call, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	// 恢复请求体，后面的 handler 可以再次读取
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	// handler.NewRequestOptions 会读完请求体，给它一个副本
+	clone := r.Clone(r.Context())
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	return callsFromOptions(handler.NewRequestOptions(clone))
+}
+
+func callsFromOptions(opts *handler.RequestOptions) ([]call, error) {
+	doc, err := parser.Parse(parser.ParseParams{Source: opts.Query})
+	if err != nil {
+		return nil, err
+	}
+	fragments := map[string]*ast.FragmentDefinition{}
+	var operations []*ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch d := def.(type) {
+		case *ast.OperationDefinition:
+			operations = append(operations, d)
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		}
+	}
+	var op *ast.OperationDefinition
+	for _, o := range operations {
+		if opts.OperationName == "" || (o.Name != nil && o.Name.Value == opts.OperationName) {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		return nil, fmt.Errorf("operation %q not found", opts.OperationName)
+	}
+
+	var calls []call
+	visited := map[string]bool{}
+	var walk func(set *ast.SelectionSet)
+	walk = func(set *ast.SelectionSet) {
+		if set == nil {
+			return
+		}
+		// 顶层字段可能写在片段里，需要展开片段，否则可以绕过限流
+		for _, sel := range set.Selections {
+			switch s := sel.(type) {
+			case *ast.Field:
+				calls = append(calls, call{operation: s.Name.Value, ticket: ticketArg(s, opts.Variables)})
+			case *ast.InlineFragment:
+				walk(s.SelectionSet)
+			case *ast.FragmentSpread:
+				if f, ok := fragments[s.Name.Value]; ok && !visited[s.Name.Value] {
+					visited[s.Name.Value] = true
+					walk(f.SelectionSet)
+				}
+			}
+		}
+	}
+	walk(op.SelectionSet)
+	return calls, nil
+}
+
+// ticketArg 取出 ticket 参数，支持字面量和变量两种写法
+func ticketArg(field *ast.Field, variables map[string]interface{}) string {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "ticket" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.StringValue:
+			return v.Value
+		case *ast.Variable:
+			ticket, _ := variables[v.Name.Value].(string)
+			return ticket
+		}
+	}
+	return ""
+}
+
+// writeTooLarge 返回 413 和 GraphQL 格式的错误
+func writeTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": nil,
+		"errors": []map[string]interface{}{{
+			"message":    fmt.Sprintf("request body exceeds %d bytes", maxBodyBytes),
+			"extensions": map[string]interface{}{"code": "REQUEST_TOO_LARGE"},
+		}},
+	})
+}
+
+// writeLimited 返回 429，响应体与 GraphQL 错误格式一致
+func writeLimited(w http.ResponseWriter, operation string, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": nil,
+		"errors": []map[string]interface{}{{
+			"message": fmt.Sprintf("too many %s requests, retry after %ds", operation, seconds),
+			"extensions": map[string]interface{}{
+				"code":       "RATE_LIMITED",
+				"retryAfter": seconds,
+			},
+		}},
+	})
+}
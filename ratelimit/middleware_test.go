@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"VoteMe/config"
+	"github.com/graphql-go/handler"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 测试别名、片段和变量中的调用都能被识别，不能绕过限流
+func TestCallsFromOptions(t *testing.T) {
+	calls, err := callsFromOptions(&handler.RequestOptions{
+		Query: `mutation V($t: String) {
+			a: vote(name: ["Alice"], ticket: $t)
+			...F
+		}
+		fragment F on Mutation { b: vote(name: ["Bob"], ticket: "literal") }
+		query Q { getCurrentTicket { ticketID } }`,
+		Variables:     map[string]interface{}{"t": "from-var"},
+		OperationName: "V",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []call{{"vote", "from-var"}, {"vote", "literal"}}, calls)
+
+	_, err = callsFromOptions(&handler.RequestOptions{Query: "{ getUserVotes(name: \"Alice\") }", OperationName: "X"})
+	assert.Error(t, err)
+}
+
+// 测试超长的请求体被识别出来，不能用填充绕过限流
+func TestParseCallsTooLarge(t *testing.T) {
+	query := `{"query": "mutation { vote(name: [\"Alice\"], ticket: \"t\") }` + strings.Repeat(" ", maxBodyBytes) + `"}`
+	r := httptest.NewRequest("POST", "/graphql", strings.NewReader(query))
+	r.Header.Set("Content-Type", "application/json")
+	_, err := parseCalls(r)
+	assert.ErrorIs(t, err, errBodyTooLarge)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := config.ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.RemoteAddr = "203.0.113.9:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "203.0.113.9", clientIP(r, trusted), "不可信的来源不采信 X-Forwarded-For")
+
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.7, 10.1.2.3")
+	assert.Equal(t, "198.51.100.7", clientIP(r, trusted), "从右往左第一个不可信的地址")
+
+	r.Header.Set("X-Forwarded-For", "10.1.2.3")
+	assert.Equal(t, "10.1.2.3", clientIP(r, trusted), "全部是可信代理时使用最左边的地址")
+}
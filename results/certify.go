@@ -0,0 +1,216 @@
+package results
+
+import (
+	"VoteMe/config"
+	"VoteMe/contest"
+	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/logging"
+	"VoteMe/model"
+	"VoteMe/tally"
+	"VoteMe/utils"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNotClosed 投票还没有结束，不能认证结果
+	ErrNotClosed = errors.New("contest must be closed before certifying results")
+	// ErrNoSigningKey 没有配置 results.signing_key
+	ErrNoSigningKey = errors.New("results.signing_key is not configured")
+	// ErrPendingVotes 最后一次刷盘后 redis 中仍有未刷入 mysql 的票数
+	ErrPendingVotes = errors.New("votes are still pending in redis")
+	// ErrTampered 数据库中的结果与签名时的内容不一致
+	ErrTampered = errors.New("certified results do not match the signed payload")
+)
+
+var signingKey atomic.Pointer[ed25519.PrivateKey]
+
+// Setup 加载签名私钥，没有配置时不能认证结果，但仍然可以查询已认证的结果
+func Setup(conf config.ResultsConf) error {
+	if conf.SigningKey == "" {
+		signingKey.Store(nil)
+		return nil
+	}
+	key, err := ParsePrivateKey([]byte(conf.SigningKey.Reveal()))
+	if err != nil {
+		return err
+	}
+	signingKey.Store(&key)
+	return nil
+}
+
+// ReloadOnSecretChange 私钥文件更新后使用新的私钥，已认证的结果不受影响
+func ReloadOnSecretChange() {
+	config.OnSecretChange(func(field string, value config.Secret) {
+		if field != "results.signing_key" {
+			return
+		}
+		if err := Setup(config.ResultsConf{SigningKey: value}); err != nil {
+			log.WithError(err).Error("reload results signing key failed, keep the old key")
+		}
+	})
+}
+
+// Migrate 创建认证结果表
+func Migrate(ctx context.Context) error {
+	return db.GetDB().WithContext(ctx).AutoMigrate(&model.Certification{}, &model.CertifiedResult{})
+}
+
+// Certify 认证最终结果：投票必须已经结束，通过串行化的刷盘执行最后一次刷盘，在持有刷盘锁时按投票方式计算结果并签名，
+// 最后把活动转为 certified。已经认证过时直接返回已有的结果，可以安全重试
+func Certify(ctx context.Context, actor string) (*Export, error) {
+	logger := logging.FromContext(ctx)
+	if existing, err := Latest(ctx); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return existing, markCertified(ctx, actor)
+	}
+
+	c, err := contest.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get contest state: %w", err)
+	}
+	if c.State != contest.Closed {
+		return nil, fmt.Errorf("%w: contest is %s", ErrNotClosed, c.State)
+	}
+	key := signingKey.Load()
+	if key == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	// 最后一次刷盘，并在持有刷盘锁时读取快照，期间后台刷盘不会修改 users.votes；刷盘后 redis 中不能再有增量，否则结果不完整
+	var (
+		export  *Export
+		payload Payload
+	)
+	err = utils.SyncThen(ctx, func(ctx context.Context) error {
+		pending, err := utils.PendingVotes(ctx)
+		if err != nil {
+			return fmt.Errorf("check pending votes: %w", err)
+		}
+		if pending != 0 {
+			return fmt.Errorf("%w: %d votes were not flushed, retry later", ErrPendingVotes, pending)
+		}
+
+		// 按配置的投票方式计算结果，排序复选的当选者和每一轮也一起签名
+		res, err := tally.Compute(ctx, config.GetGlobalConf().VotingConfig)
+		if err != nil {
+			return fmt.Errorf("snapshot votes: %w", err)
+		}
+		payload = Payload{
+			Version:     FormatVersion,
+			Contest:     keys.Default().Prefix(),
+			ClosedAt:    c.UpdatedAt.UTC().Format(time.RFC3339),
+			CertifiedAt: time.Now().UTC().Format(time.RFC3339),
+			CertifiedBy: actor,
+			Mode:        res.Mode,
+			Winners:     res.Winners,
+			Ballots:     res.Ballots,
+		}
+		for _, t := range res.Totals {
+			payload.Results = append(payload.Results, Tally{Candidate: t.Name, Votes: t.Votes})
+			payload.Total += t.Votes
+		}
+		for i, r := range res.Rounds {
+			round := Round{Round: i + 1, Eliminated: r.Eliminated, Exhausted: r.Exhausted}
+			for name, votes := range r.Counts {
+				round.Counts = append(round.Counts, Tally{Candidate: name, Votes: votes})
+			}
+			payload.Rounds = append(payload.Rounds, round)
+		}
+		export, err = Sign(payload, *key)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("final sync votes: %w", err)
+	}
+	canonical, _ := Canonical(payload)
+
+	cert := model.Certification{
+		Contest:     payload.Contest,
+		CertifiedBy: actor,
+		Payload:     string(canonical),
+		Hash:        export.Hash,
+		Signature:   export.Signature,
+		PublicKey:   export.PublicKey,
+	}
+	for _, t := range payload.Results {
+		cert.Results = append(cert.Results, model.CertifiedResult{Candidate: t.Candidate, Votes: t.Votes})
+	}
+	// gorm 在同一个事务中写入认证记录和结果快照，活动标识上的唯一索引保证只认证一次
+	if err := db.GetDB().WithContext(ctx).Create(&cert).Error; err != nil {
+		return nil, fmt.Errorf("save certified results: %w", err)
+	}
+	logger.WithFields(log.Fields{"sha256": export.Hash, "total": payload.Total}).Info("results certified")
+	return export, markCertified(ctx, actor)
+}
+
+// markCertified 把活动转为 certified，已经是 certified 时忽略
+func markCertified(ctx context.Context, actor string) error {
+	c, err := contest.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("get contest state: %w", err)
+	}
+	if c.State == contest.Certified {
+		return nil
+	}
+	if _, err := contest.Transition(ctx, contest.Certified, "results certified", actor); err != nil {
+		return fmt.Errorf("results were certified but contest state was not updated: %w", err)
+	}
+	return nil
+}
+
+// Latest 返回当前活动已认证的结果，还没有认证时返回 nil
+// 会用结果表中的快照重新计算哈希，与签名时的内容不一致时返回 ErrTampered。
+// 配置了签名私钥时签名必须由该私钥签发，认证之后更换私钥会导致已认证的结果被报告为 ErrTampered
+func Latest(ctx context.Context) (*Export, error) {
+	var cert model.Certification
+	err := db.GetDB().WithContext(ctx).Preload("Results").
+		Where("contest = ?", keys.Default().Prefix()).Take(&cert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load certified results: %w", err)
+	}
+	e, err := Decode([]byte(`{"payload":` + cert.Payload + `}`))
+	if err != nil {
+		return nil, err
+	}
+	e.Algorithm, e.Hash, e.Signature, e.PublicKey = Algorithm, cert.Hash, cert.Signature, cert.PublicKey
+	if !sameResults(e.Payload.Results, cert.Results) {
+		return nil, ErrTampered
+	}
+	// 配置了签名私钥时用它的公钥校验，不信任同一行中保存的公钥，否则改写数据库的人可以用自己的私钥重新签名；
+	// 没有配置私钥时只能用保存的公钥校验，这时只能发现内容与签名不一致，不能证明是谁签的
+	var pinned ed25519.PublicKey
+	if key := signingKey.Load(); key != nil {
+		pinned = key.Public().(ed25519.PublicKey)
+	}
+	if err := Verify(e, pinned); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTampered, err)
+	}
+	return e, nil
+}
+
+func sameResults(signed []Tally, stored []model.CertifiedResult) bool {
+	if len(signed) != len(stored) {
+		return false
+	}
+	votes := make(map[string]int64, len(stored))
+	for _, r := range stored {
+		votes[r.Candidate] = r.Votes
+	}
+	for _, t := range signed {
+		if v, ok := votes[t.Candidate]; !ok || v != t.Votes {
+			return false
+		}
+	}
+	return true
+}
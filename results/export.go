@@ -0,0 +1,325 @@
+package results
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FormatVersion 导出格式的版本，格式变化时加一
+// 2：增加投票方式、当选者和即时决选的每一轮，版本 1 的导出仍然可以校验
+const FormatVersion = 2
+
+// Algorithm 签名算法
+const Algorithm = "ed25519"
+
+// ErrBadSignature 签名或哈希校验失败，结果可能被篡改
+var ErrBadSignature = errors.New("results signature verification failed")
+
+// Tally 单个选手的票数
+type Tally struct {
+	Candidate string `json:"candidate"`
+	Votes     int64  `json:"votes"`
+}
+
+// Round 即时决选的一轮，Counts 按选手名排序
+type Round struct {
+	Round      int      `json:"round"`
+	Counts     []Tally  `json:"counts"`
+	Eliminated []string `json:"eliminated"`
+	Exhausted  int64    `json:"exhausted"`
+}
+
+// Payload 被签名的结果内容，字段顺序固定，Results 按选手名排序，序列化后即为规范化 JSON
+// 版本 2 增加的字段为空时省略，版本 1 的导出序列化后与签名时一致
+type Payload struct {
+	Version     int      `json:"version"`
+	Contest     string   `json:"contest"`     // 活动标识，即 redis 键前缀
+	ClosedAt    string   `json:"closedAt"`    // 投票结束时间，RFC3339
+	CertifiedAt string   `json:"certifiedAt"` // 认证时间，RFC3339
+	CertifiedBy string   `json:"certifiedBy"`
+	Total       int64    `json:"total"`
+	Results     []Tally  `json:"results"`           // 按投票方式统计的票数，加权投票为分数，排序复选为第一选择的票数
+	Mode        string   `json:"mode,omitempty"`    // 投票方式
+	Winners     []string `json:"winners,omitempty"` // 当选者，排序复选时为即时决选的结果
+	Ballots     int64    `json:"ballots,omitempty"` // 排序复选参与计算的选票数
+	Rounds      []Round  `json:"rounds,omitempty"`  // 即时决选的每一轮
+}
+
+// Export 签名后的结果，可以导出为 JSON 或 CSV，第三方使用 voteme verify-results 离线校验
+type Export struct {
+	Payload   Payload `json:"payload"`
+	Algorithm string  `json:"algorithm"`
+	Hash      string  `json:"sha256"`    // 规范化 JSON 的 sha256，十六进制
+	Signature string  `json:"signature"` // 对哈希的 Ed25519 签名，base64
+	PublicKey string  `json:"publicKey"` // 签名公钥，base64；校验时应使用事先公布的公钥，而不是这里的值
+}
+
+// Canonical 返回规范化 JSON，Results 会先按选手名排序
+func Canonical(p Payload) ([]byte, error) {
+	p.Results = sortedTallies(p.Results)
+	rounds := make([]Round, len(p.Rounds))
+	for i, r := range p.Rounds {
+		r.Counts = sortedTallies(r.Counts)
+		rounds[i] = r
+	}
+	if p.Rounds != nil {
+		p.Rounds = rounds
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func sortedTallies(tallies []Tally) []Tally {
+	if tallies == nil {
+		return nil
+	}
+	tallies = append([]Tally(nil), tallies...)
+	sort.Slice(tallies, func(i, j int) bool { return tallies[i].Candidate < tallies[j].Candidate })
+	return tallies
+}
+
+// Sign 计算规范化 JSON 的哈希并签名
+func Sign(p Payload, key ed25519.PrivateKey) (*Export, error) {
+	canonical, err := Canonical(p)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(canonical)
+	return &Export{
+		Payload:   p,
+		Algorithm: Algorithm,
+		Hash:      hex.EncodeToString(sum[:]),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum[:])),
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}, nil
+}
+
+// Verify 重新计算哈希并校验签名，pinned 为空时使用导出中自带的公钥，只能证明内容与签名一致
+func Verify(e *Export, pinned ed25519.PublicKey) error {
+	if e.Algorithm != Algorithm {
+		return fmt.Errorf("unsupported algorithm %q", e.Algorithm)
+	}
+	var total int64
+	for _, t := range e.Payload.Results {
+		total += t.Votes
+	}
+	if total != e.Payload.Total {
+		return fmt.Errorf("%w: total %d does not match the sum of results %d", ErrBadSignature, e.Payload.Total, total)
+	}
+	candidates := make(map[string]bool, len(e.Payload.Results))
+	for _, t := range e.Payload.Results {
+		candidates[t.Candidate] = true
+	}
+	for _, w := range e.Payload.Winners {
+		if !candidates[w] {
+			return fmt.Errorf("%w: winner %s is not in the results", ErrBadSignature, w)
+		}
+	}
+	canonical, err := Canonical(e.Payload)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(canonical)
+	if hex.EncodeToString(sum[:]) != e.Hash {
+		return fmt.Errorf("%w: sha256 does not match the results", ErrBadSignature)
+	}
+	key := pinned
+	if key == nil {
+		raw, err := base64.StdEncoding.DecodeString(e.PublicKey)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid public key in export")
+		}
+		key = raw
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Signature)
+	if err != nil || !ed25519.Verify(key, sum[:], sig) {
+		return fmt.Errorf("%w: signature does not match the public key", ErrBadSignature)
+	}
+	return nil
+}
+
+// EncodeJSON 导出为带缩进的 JSON
+func EncodeJSON(e *Export) ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// CSV 元数据行的前缀，之后是 candidate,votes 表头和每个选手一行
+const csvMetaPrefix = "# "
+
+// EncodeCSV 导出为 CSV，开头用 # 注释行记录签名所需的元数据，便于直接用表格软件打开
+func EncodeCSV(e *Export) ([]byte, error) {
+	var buf bytes.Buffer
+	meta := [][2]string{
+		{"version", strconv.Itoa(e.Payload.Version)},
+		{"contest", e.Payload.Contest},
+		{"closed_at", e.Payload.ClosedAt},
+		{"certified_at", e.Payload.CertifiedAt},
+		{"certified_by", e.Payload.CertifiedBy},
+		{"total", strconv.FormatInt(e.Payload.Total, 10)},
+		{"algorithm", e.Algorithm},
+		{"sha256", e.Hash},
+		{"signature", e.Signature},
+		{"public_key", e.PublicKey},
+	}
+	// 版本 2 增加的字段为空时不写，当选者和每一轮用单行 JSON 表示
+	if p := e.Payload; p.Mode != "" {
+		meta = append(meta, [2]string{"mode", p.Mode})
+	}
+	for _, m := range []struct {
+		key   string
+		value interface{}
+		empty bool
+	}{
+		{"winners", e.Payload.Winners, len(e.Payload.Winners) == 0},
+		{"ballots", e.Payload.Ballots, e.Payload.Ballots == 0},
+		{"rounds", e.Payload.Rounds, len(e.Payload.Rounds) == 0},
+	} {
+		if m.empty {
+			continue
+		}
+		data, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		meta = append(meta, [2]string{m.key, string(data)})
+	}
+	for _, m := range meta {
+		fmt.Fprintf(&buf, "%s%s: %s\n", csvMetaPrefix, m[0], strings.ReplaceAll(m[1], "\n", " "))
+	}
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"candidate", "votes"})
+	for _, t := range e.Payload.Results {
+		w.Write([]string{t.Candidate, strconv.FormatInt(t.Votes, 10)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Decode 解析 EncodeJSON 或 EncodeCSV 导出的结果
+func Decode(data []byte) (*Export, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		e := &Export{}
+		if err := json.Unmarshal(trimmed, e); err != nil {
+			return nil, fmt.Errorf("decode json export: %w", err)
+		}
+		return e, nil
+	}
+	return decodeCSV(data)
+}
+
+func decodeCSV(data []byte) (*Export, error) {
+	meta := map[string]string{}
+	var body bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, csvMetaPrefix); ok && body.Len() == 0 {
+			// 编辑器可能去掉空值后的空格，按冒号切分后只去掉一个前导空格
+			key, value, _ := strings.Cut(rest, ":")
+			meta[key] = strings.TrimPrefix(value, " ")
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(&body).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decode csv export: %w", err)
+	}
+	if len(records) == 0 || len(records[0]) != 2 || records[0][0] != "candidate" || records[0][1] != "votes" {
+		return nil, fmt.Errorf("decode csv export: missing candidate,votes header")
+	}
+	e := &Export{
+		Payload: Payload{
+			Contest:     meta["contest"],
+			ClosedAt:    meta["closed_at"],
+			CertifiedAt: meta["certified_at"],
+			CertifiedBy: meta["certified_by"],
+		},
+		Algorithm: meta["algorithm"],
+		Hash:      meta["sha256"],
+		Signature: meta["signature"],
+		PublicKey: meta["public_key"],
+	}
+	if e.Payload.Version, err = strconv.Atoi(meta["version"]); err != nil {
+		return nil, fmt.Errorf("decode csv export: invalid version: %w", err)
+	}
+	if e.Payload.Total, err = strconv.ParseInt(meta["total"], 10, 64); err != nil {
+		return nil, fmt.Errorf("decode csv export: invalid total: %w", err)
+	}
+	e.Payload.Mode = meta["mode"]
+	for key, dst := range map[string]interface{}{"winners": &e.Payload.Winners, "ballots": &e.Payload.Ballots, "rounds": &e.Payload.Rounds} {
+		if v, ok := meta[key]; ok {
+			if err := json.Unmarshal([]byte(v), dst); err != nil {
+				return nil, fmt.Errorf("decode csv export: invalid %s: %w", key, err)
+			}
+		}
+	}
+	for _, r := range records[1:] {
+		votes, err := strconv.ParseInt(r[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("decode csv export: invalid votes for %s: %w", r[0], err)
+		}
+		e.Payload.Results = append(e.Payload.Results, Tally{Candidate: r[0], Votes: votes})
+	}
+	return e, nil
+}
+
+// ParsePrivateKey 解析 PKCS#8 PEM 格式的 Ed25519 私钥
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("signing key must be a PKCS#8 PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not ed25519", key)
+	}
+	return ed, nil
+}
+
+// ParsePublicKey 解析 PEM 格式（openssl pkey -pubout）或 base64 的 Ed25519 公钥
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		ed, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("public key is %T, not ed25519", key)
+		}
+		return ed, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be PEM or base64 of %d bytes", ed25519.PublicKeySize)
+	}
+	return raw, nil
+}
@@ -0,0 +1,67 @@
+package results
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testExport(t *testing.T) (*Export, ed25519.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	e, err := Sign(Payload{
+		Version:     FormatVersion,
+		Contest:     "Voteme",
+		ClosedAt:    "2024-05-01T22:00:00Z",
+		CertifiedAt: "2024-05-01T22:05:00Z",
+		CertifiedBy: "alice",
+		Total:       7,
+		Results:     []Tally{{Candidate: "bob", Votes: 3}, {Candidate: "a,\"b\"", Votes: 4}},
+		Mode:        "ranked",
+		Winners:     []string{"bob"},
+		Ballots:     7,
+		Rounds: []Round{
+			{Round: 1, Counts: []Tally{{Candidate: "bob", Votes: 3}, {Candidate: "a,\"b\"", Votes: 4}}, Eliminated: []string{}},
+			{Round: 2, Counts: []Tally{{Candidate: "bob", Votes: 4}}, Eliminated: []string{"a,\"b\""}, Exhausted: 3},
+		},
+	}, priv)
+	assert.NoError(t, err)
+	return e, pub
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	e, pub := testExport(t)
+	assert.NoError(t, Verify(e, pub))
+
+	jsonExport, err := EncodeJSON(e)
+	assert.NoError(t, err)
+	csvExport, err := EncodeCSV(e)
+	assert.NoError(t, err)
+	for _, data := range [][]byte{jsonExport, csvExport} {
+		decoded, err := Decode(data)
+		assert.NoError(t, err)
+		assert.NoError(t, Verify(decoded, pub), string(data))
+		assert.Equal(t, []string{"bob"}, decoded.Payload.Winners)
+		assert.Len(t, decoded.Payload.Rounds, 2)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	e, pub := testExport(t)
+
+	tampered := *e
+	tampered.Payload.Results = []Tally{{Candidate: "bob", Votes: 4}, {Candidate: "a,\"b\"", Votes: 3}}
+	assert.ErrorIs(t, Verify(&tampered, pub), ErrBadSignature, "票数被修改")
+
+	winner := *e
+	winner.Payload.Winners = []string{"a,\"b\""}
+	assert.ErrorIs(t, Verify(&winner, pub), ErrBadSignature, "当选者被修改")
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	assert.ErrorIs(t, Verify(e, other), ErrBadSignature, "不是公布的公钥签名的")
+
+	wrongTotal := *e
+	wrongTotal.Payload.Total = 8
+	assert.ErrorIs(t, Verify(&wrongTotal, pub), ErrBadSignature)
+}
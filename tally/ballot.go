@@ -0,0 +1,111 @@
+package tally
+
+import (
+	"VoteMe/config"
+	"VoteMe/db"
+	"VoteMe/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+)
+
+// Migrate 创建排序复选的选票表
+func Migrate(ctx context.Context) error {
+	return db.GetDB().WithContext(ctx).AutoMigrate(&model.Ballot{})
+}
+
+// SaveBallot 保存一张排序复选的选票
+func SaveBallot(ctx context.Context, ranking []string) error {
+	data, err := json.Marshal(ranking)
+	if err != nil {
+		return err
+	}
+	return db.GetDB().WithContext(ctx).Create(&model.Ballot{Ranking: string(data)}).Error
+}
+
+// Total 选手的票数
+type Total struct {
+	Name  string
+	Votes int64
+}
+
+// Results 按投票方式计算的结果
+type Results struct {
+	Mode    string
+	Winners []string
+	Totals  []Total // 已刷盘的票数，按票数从高到低排列；排序复选时为已保存的选票中第一选择的票数
+	Rounds  []Round // 只有排序复选才有
+	Ballots int64   // 排序复选参与计算的选票数
+}
+
+// Compute 按投票方式计算结果，读取的是已刷盘的数据，尚未刷盘的投票不计入
+func Compute(ctx context.Context, conf config.VotingConf) (*Results, error) {
+	conn := db.GetDB().WithContext(ctx)
+	var users []model.User
+	if err := conn.Select("name", "votes").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("load candidates: %w", err)
+	}
+	res := &Results{Mode: conf.Mode}
+	totals := make(map[string]int64, len(users))
+	candidates := make([]string, 0, len(users))
+	for _, u := range users {
+		totals[u.Name] = int64(u.Votes)
+		candidates = append(candidates, u.Name)
+		res.Totals = append(res.Totals, Total{Name: u.Name, Votes: int64(u.Votes)})
+	}
+	sortTotals(res.Totals)
+
+	if conf.Mode != config.VotingRanked {
+		res.Winners = Leaders(totals)
+		return res, nil
+	}
+	ballots, err := loadBallots(conn)
+	if err != nil {
+		return nil, err
+	}
+	runoff := InstantRunoff(candidates, ballots)
+	res.Winners = runoff.Winners
+	res.Rounds = runoff.Rounds
+	res.Ballots = int64(len(ballots))
+	// 只统计已保存的选票，第一选择的票数也从选票计算，与每一轮的结果一致，不使用可能多计或少计的 users.votes
+	if len(runoff.Rounds) > 0 {
+		res.Totals = res.Totals[:0]
+		for name, votes := range runoff.Rounds[0].Counts {
+			res.Totals = append(res.Totals, Total{Name: name, Votes: votes})
+		}
+		sortTotals(res.Totals)
+	}
+	return res, nil
+}
+
+// sortTotals 按票数从高到低排列，票数相同时按选手名排列
+func sortTotals(totals []Total) {
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Votes != totals[j].Votes {
+			return totals[i].Votes > totals[j].Votes
+		}
+		return totals[i].Name < totals[j].Name
+	})
+}
+
+// loadBallots 分批读取全部选票
+func loadBallots(conn *gorm.DB) ([][]string, error) {
+	var ballots [][]string
+	var rows []model.Ballot
+	err := conn.Select("id", "ranking").FindInBatches(&rows, 1000, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			var ranking []string
+			if err := json.Unmarshal([]byte(row.Ranking), &ranking); err != nil {
+				return fmt.Errorf("decode ballot %d: %w", row.ID, err)
+			}
+			ballots = append(ballots, ranking)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("load ballots: %w", err)
+	}
+	return ballots, nil
+}
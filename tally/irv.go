@@ -0,0 +1,80 @@
+package tally
+
+import "sort"
+
+// Round 即时决选的一轮
+type Round struct {
+	Counts     map[string]int64 // 仍在竞选中的选手本轮得票，每张选票计入其排名最靠前且未被淘汰的选手
+	Eliminated []string         // 本轮被淘汰的选手
+	Exhausted  int64            // 排名中的选手已全部淘汰的选票数
+}
+
+// Runoff 即时决选的结果
+type Runoff struct {
+	Rounds  []Round
+	Winners []string // 通常只有一个，最后剩下的选手票数相同时全部返回
+}
+
+// InstantRunoff 按即时决选计算排序复选的结果
+// 每轮统计选票中排名最靠前且未被淘汰的选手，有人得票过半时当选；否则淘汰得票最少的选手（并列时一起淘汰）进入下一轮
+// 如果一起淘汰会淘汰所有剩下的选手，这些选手并列当选。不在 candidates 中的选手忽略
+func InstantRunoff(candidates []string, ballots [][]string) Runoff {
+	active := make(map[string]bool, len(candidates))
+	for _, c := range candidates {
+		active[c] = true
+	}
+	var result Runoff
+	for len(active) > 0 {
+		round := Round{Counts: make(map[string]int64, len(active))}
+		for c := range active {
+			round.Counts[c] = 0
+		}
+		var total int64
+		for _, ballot := range ballots {
+			counted := false
+			for _, name := range ballot {
+				if active[name] {
+					round.Counts[name]++
+					total++
+					counted = true
+					break
+				}
+			}
+			if !counted {
+				round.Exhausted++
+			}
+		}
+		if total == 0 {
+			// 没有有效选票，不产生当选者
+			result.Rounds = append(result.Rounds, round)
+			return result
+		}
+
+		var lowest int64 = -1
+		for c, n := range round.Counts {
+			if n*2 > total || len(active) == 1 {
+				result.Rounds = append(result.Rounds, round)
+				result.Winners = []string{c}
+				return result
+			}
+			if lowest < 0 || n < lowest {
+				lowest = n
+			}
+		}
+		for c, n := range round.Counts {
+			if n == lowest {
+				round.Eliminated = append(round.Eliminated, c)
+			}
+		}
+		sort.Strings(round.Eliminated)
+		result.Rounds = append(result.Rounds, round)
+		if len(round.Eliminated) == len(active) {
+			result.Winners = round.Eliminated
+			return result
+		}
+		for _, c := range round.Eliminated {
+			delete(active, c)
+		}
+	}
+	return result
+}
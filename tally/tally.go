@@ -0,0 +1,152 @@
+package tally
+
+import (
+	"VoteMe/config"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrInvalidBallot 选票不符合投票规则，具体是哪条规则见 RuleError
+var ErrInvalidBallot = errors.New("invalid ballot")
+
+// 选票规则，作为 GraphQL 错误 extensions.rule 返回给客户端
+const (
+	RuleMinSelections    = "min_selections"    // 选择的选手太少
+	RuleMaxSelections    = "max_selections"    // 选择的选手太多
+	RuleDuplicate        = "duplicate"         // 同一个选手选了多次
+	RuleRequiredCategory = "required_category" // 必选分类中选择的选手不够
+	RuleNameType         = "name_type"         // 选手名不是字符串
+)
+
+// RuleError 选票违反的规则，实现了 graphql-go 的 ExtendedError，客户端可以按 extensions.rule 提示用户
+type RuleError struct {
+	Rule    string
+	Message string
+	Details map[string]interface{} // 规则的参数和选票的实际情况，例如 max 和 got
+}
+
+func (e *RuleError) Error() string {
+	return "invalid ballot: " + e.Message
+}
+
+func (e *RuleError) Unwrap() error {
+	return ErrInvalidBallot
+}
+
+// Extensions 返回 GraphQL 错误的 extensions
+func (e *RuleError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": "INVALID_BALLOT", "rule": e.Rule}
+	for k, v := range e.Details {
+		ext[k] = v
+	}
+	return ext
+}
+
+// Bounds 返回选票最少和最多能选几个选手，max 为 0 表示不限
+func Bounds(conf config.VotingConf) (min, max int) {
+	min, max = conf.MinSelections, conf.MaxSelections
+	if min < 1 {
+		min = 1
+	}
+	switch conf.Mode {
+	case config.VotingSingle:
+		max = 1
+	case config.VotingWeighted:
+		// 没有分数的位置不计票
+		if max == 0 || max > len(conf.Points) {
+			max = len(conf.Points)
+		}
+	}
+	return min, max
+}
+
+// Validate 按投票规则检查选票，names 为按顺序选择的选手，不合法时返回 *RuleError
+// 在消耗票据的使用次数之前调用，不合法的选票不会占用票据
+func Validate(conf config.VotingConf, names []string) error {
+	min, max := Bounds(conf)
+	if len(names) < min {
+		return &RuleError{
+			Rule:    RuleMinSelections,
+			Message: fmt.Sprintf("ballot must select at least %d candidates, got %d", min, len(names)),
+			Details: map[string]interface{}{"min": min, "got": len(names)},
+		}
+	}
+	if max > 0 && len(names) > max {
+		return &RuleError{
+			Rule:    RuleMaxSelections,
+			Message: fmt.Sprintf("ballot can select at most %d candidates, got %d", max, len(names)),
+			Details: map[string]interface{}{"max": max, "got": len(names)},
+		}
+	}
+	// 加权和排序复选按位置计分，同一个选手出现两次没有意义，不受 allow_duplicates 影响
+	if !conf.AllowDuplicates || conf.Mode == config.VotingWeighted || conf.Mode == config.VotingRanked {
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if seen[name] {
+				return &RuleError{
+					Rule:    RuleDuplicate,
+					Message: fmt.Sprintf("candidate %s is selected more than once", name),
+					Details: map[string]interface{}{"candidate": name},
+				}
+			}
+			seen[name] = true
+		}
+	}
+	for _, category := range conf.Categories {
+		members := make(map[string]bool, len(category.Candidates))
+		for _, c := range category.Candidates {
+			members[c] = true
+		}
+		got := 0
+		for _, name := range names {
+			if members[name] {
+				got++
+			}
+		}
+		if got < category.Min {
+			return &RuleError{
+				Rule:    RuleRequiredCategory,
+				Message: fmt.Sprintf("ballot must select at least %d candidates in category %s, got %d", category.Min, category.Name, got),
+				Details: map[string]interface{}{"category": category.Name, "min": category.Min, "got": got},
+			}
+		}
+	}
+	return nil
+}
+
+// Weight 返回选票中第 i 个选择给选手加的票数
+// 排序复选只把第一选择计入实时票数，最终结果由 InstantRunoff 计算
+func Weight(conf config.VotingConf, i int) int64 {
+	switch conf.Mode {
+	case config.VotingWeighted:
+		if i < len(conf.Points) {
+			return int64(conf.Points[i])
+		}
+		return 0
+	case config.VotingRanked:
+		if i == 0 {
+			return 1
+		}
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Leaders 返回票数最多的选手，并列时全部返回，没有人得票时返回空
+func Leaders(totals map[string]int64) []string {
+	var best int64
+	var leaders []string
+	for name, votes := range totals {
+		switch {
+		case votes > best:
+			best = votes
+			leaders = []string{name}
+		case votes == best && votes > 0:
+			leaders = append(leaders, name)
+		}
+	}
+	sort.Strings(leaders)
+	return leaders
+}
@@ -0,0 +1,82 @@
+package tally
+
+import (
+	"VoteMe/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	single := config.VotingConf{Mode: config.VotingSingle}
+	assert.NoError(t, Validate(single, []string{"a"}))
+	assert.ErrorIs(t, Validate(single, []string{"a", "b"}), ErrInvalidBallot)
+	assert.ErrorIs(t, Validate(single, nil), ErrInvalidBallot)
+
+	approval := config.VotingConf{Mode: config.VotingApproval, MaxSelections: 2}
+	assert.NoError(t, Validate(approval, []string{"a", "b"}))
+	assert.ErrorIs(t, Validate(approval, []string{"a", "b", "c"}), ErrInvalidBallot)
+
+	weighted := config.VotingConf{Mode: config.VotingWeighted, Points: []int{3, 2, 1}}
+	assert.NoError(t, Validate(weighted, []string{"a", "b", "c"}))
+	assert.ErrorIs(t, Validate(weighted, []string{"a", "b", "c", "d"}), ErrInvalidBallot)
+	assert.Equal(t, []int64{3, 2, 1}, []int64{Weight(weighted, 0), Weight(weighted, 1), Weight(weighted, 2)})
+
+	ranked := config.VotingConf{Mode: config.VotingRanked}
+	assert.Equal(t, []int64{1, 0}, []int64{Weight(ranked, 0), Weight(ranked, 1)})
+}
+
+func TestValidateRules(t *testing.T) {
+	conf := config.VotingConf{
+		Mode:          config.VotingApproval,
+		MinSelections: 2,
+		MaxSelections: 3,
+		Categories:    []config.CategoryConf{{Name: "rookie", Candidates: []string{"d", "e"}, Min: 1}},
+	}
+	rule := func(names ...string) map[string]interface{} {
+		var rerr *RuleError
+		if !assert.ErrorAs(t, Validate(conf, names), &rerr) {
+			return nil
+		}
+		return rerr.Extensions()
+	}
+
+	assert.NoError(t, Validate(conf, []string{"a", "d"}))
+	assert.Equal(t, map[string]interface{}{"code": "INVALID_BALLOT", "rule": RuleMinSelections, "min": 2, "got": 1}, rule("d"))
+	assert.Equal(t, map[string]interface{}{"code": "INVALID_BALLOT", "rule": RuleMaxSelections, "max": 3, "got": 4}, rule("a", "b", "c", "d"))
+	assert.Equal(t, map[string]interface{}{"code": "INVALID_BALLOT", "rule": RuleDuplicate, "candidate": "d"}, rule("d", "a", "d"))
+	assert.Equal(t, map[string]interface{}{"code": "INVALID_BALLOT", "rule": RuleRequiredCategory, "category": "rookie", "min": 1, "got": 0}, rule("a", "b"))
+
+	// 开启 allow_duplicates 后 approval 可以重复选，weighted 仍然不可以
+	conf.AllowDuplicates = true
+	assert.NoError(t, Validate(conf, []string{"d", "d"}))
+	conf.Mode, conf.Points = config.VotingWeighted, []int{3, 2, 1}
+	assert.Equal(t, RuleDuplicate, rule("d", "d")["rule"])
+}
+
+func TestLeaders(t *testing.T) {
+	assert.Equal(t, []string{"a", "c"}, Leaders(map[string]int64{"a": 3, "b": 1, "c": 3}))
+	assert.Empty(t, Leaders(map[string]int64{"a": 0, "b": 0}))
+}
+
+func TestInstantRunoff(t *testing.T) {
+	candidates := []string{"a", "b", "c", "d"}
+	ballots := [][]string{
+		{"a"}, {"a"}, {"a"}, {"a"},
+		{"b", "c"}, {"b", "c"}, {"b", "c"},
+		{"c", "b"}, {"c", "b", "a"},
+		{"x"}, // 不在名单中的选手忽略，选票视为用完
+	}
+	r := InstantRunoff(candidates, ballots)
+	// 第一选择 a 最多，但没有过半；淘汰 d、c 之后 c 的选票转给 b，b 过半当选
+	assert.Equal(t, []string{"b"}, r.Winners)
+	assert.Len(t, r.Rounds, 3)
+	assert.Equal(t, []string{"d"}, r.Rounds[0].Eliminated)
+	assert.Equal(t, int64(1), r.Rounds[0].Exhausted)
+	assert.Equal(t, []string{"c"}, r.Rounds[1].Eliminated)
+	assert.Equal(t, map[string]int64{"a": 4, "b": 5}, r.Rounds[2].Counts)
+
+	// 剩下的选手票数相同时并列当选
+	tie := InstantRunoff([]string{"a", "b"}, [][]string{{"a"}, {"b"}})
+	assert.Equal(t, []string{"a", "b"}, tie.Winners)
+	assert.Empty(t, InstantRunoff(candidates, nil).Winners)
+}
@@ -0,0 +1,51 @@
+package tickets
+
+import (
+	"VoteMe/db"
+	"VoteMe/logging"
+	"context"
+	"time"
+)
+
+// pruneBatch 每条 DELETE 最多删除多少行，避免长时间锁表
+const pruneBatch = 1000
+
+// Pruner 每隔 interval 删除签发时间早于 retention 之前、并且使用次数已补全的票据记录，ctx 取消后退出
+func Pruner(retention, interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := Prune(ctx, time.Now().Add(-retention))
+				if err != nil {
+					// 下次再清理，不影响投票
+					logging.FromContext(ctx).WithError(err).Warn("prune ticket history failed")
+					continue
+				}
+				if n > 0 {
+					logging.FromContext(ctx).Infof("pruned %d tickets issued before retention", n)
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Prune 分批删除签发时间早于 before 的票据记录，返回删除的行数
+func Prune(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		res := db.GetDB().WithContext(ctx).Exec("DELETE FROM tickets WHERE finalized = ? AND issued_at < ? LIMIT ?",
+			true, before, pruneBatch)
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+		if res.RowsAffected < pruneBatch {
+			return total, nil
+		}
+	}
+}
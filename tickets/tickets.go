@@ -0,0 +1,74 @@
+package tickets
+
+import (
+	"VoteMe/control"
+	"VoteMe/db"
+	"VoteMe/logging"
+	"VoteMe/model"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// finalizeBatch 每次最多补全多少张过期票据的使用次数
+const finalizeBatch = 100
+
+var instance = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + ":" + strconv.Itoa(os.Getpid())
+}()
+
+// Instance 当前实例的标识，主机名加进程号
+func Instance() string {
+	return instance
+}
+
+// Migrate 创建或升级 tickets 表
+func Migrate(ctx context.Context) error {
+	return db.GetDB().WithContext(ctx).AutoMigrate(&model.Ticket{})
+}
+
+// Record 记录一张新签发的票据
+func Record(ctx context.Context, ticketID string, maxUses int, ttl time.Duration) error {
+	now := time.Now()
+	return db.GetDB().WithContext(ctx).Create(&model.Ticket{
+		TicketID:  ticketID,
+		MaxUses:   maxUses,
+		IssuedAt:  now,
+		ExpiredAt: now.Add(ttl),
+		Instance:  instance,
+	}).Error
+}
+
+// FinalizeExpired 把已过期票据的使用次数从 redis 复制到 tickets 表
+// 票据过期后不会再被使用，此时的使用次数就是最终结果；任何实例都可以执行，重复执行没有影响
+func FinalizeExpired(ctx context.Context) (int, error) {
+	conn := db.GetDB().WithContext(ctx)
+	var expired []model.Ticket
+	err := conn.Select("id", "ticket_id").Where("finalized = ? AND expired_at <= ?", false, time.Now()).
+		Order("expired_at").Limit(finalizeBatch).Find(&expired).Error
+	if err != nil {
+		return 0, fmt.Errorf("find expired tickets: %w", err)
+	}
+	for i, t := range expired {
+		uses, err := control.TicketUses(ctx, t.TicketID)
+		if errors.Is(err, control.ErrTicketUnknown) {
+			// 签发记录已超过保留时间，使用次数无法找回，保留已有的值
+			logging.FromContext(ctx).WithField("ticket_id", t.TicketID).Warn("ticket uses lost, issued record expired in redis")
+			err = conn.Model(&model.Ticket{}).Where("id = ?", t.ID).Update("finalized", true).Error
+		} else if err == nil {
+			err = conn.Model(&model.Ticket{}).Where("id = ?", t.ID).
+				Updates(map[string]interface{}{"uses": uses, "finalized": true}).Error
+		}
+		if err != nil {
+			return i, fmt.Errorf("finalize ticket %s: %w", t.TicketID, err)
+		}
+	}
+	return len(expired), nil
+}
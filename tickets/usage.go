@@ -0,0 +1,62 @@
+package tickets
+
+import (
+	"VoteMe/db"
+	"VoteMe/model"
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxBuckets 一次查询最多返回多少个时间段
+const maxBuckets = 1000
+
+// Bucket 一个时间段内签发的票据及其使用情况
+type Bucket struct {
+	Start   time.Time
+	Tickets int64 // 签发的票据数
+	Uses    int64 // 已补全的使用次数，尚未过期的票据不计入
+	MaxUses int64 // 最大使用次数之和
+	Pending int64 // 使用次数尚未补全的票据数
+}
+
+// Usage 按签发时间统计 [from, to) 内的票据使用情况，每 bucket 一段，没有票据的时间段不返回
+func Usage(ctx context.Context, from, to time.Time, bucket time.Duration) ([]Bucket, error) {
+	if bucket < time.Second {
+		return nil, fmt.Errorf("bucket must be at least 1s")
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("to must be after from")
+	}
+	if to.Sub(from)/bucket > maxBuckets {
+		return nil, fmt.Errorf("too many buckets, at most %d", maxBuckets)
+	}
+	seconds := int64(bucket / time.Second)
+	var rows []struct {
+		Slot    int64
+		Tickets int64
+		Uses    int64
+		MaxUses int64
+		Pending int64
+	}
+	err := db.GetDB().WithContext(ctx).Model(&model.Ticket{}).
+		Select("FLOOR((UNIX_TIMESTAMP(issued_at) - ?) / ?) AS slot, COUNT(*) AS tickets, "+
+			"SUM(uses) AS uses, SUM(max_uses) AS max_uses, SUM(CASE WHEN finalized THEN 0 ELSE 1 END) AS pending",
+			from.Unix(), seconds).
+		Where("issued_at >= ? AND issued_at < ?", from, to).
+		Group("slot").Order("slot").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]Bucket, 0, len(rows))
+	for _, r := range rows {
+		buckets = append(buckets, Bucket{
+			Start:   from.Add(time.Duration(r.Slot*seconds) * time.Second),
+			Tickets: r.Tickets,
+			Uses:    r.Uses,
+			MaxUses: r.MaxUses,
+			Pending: r.Pending,
+		})
+	}
+	return buckets, nil
+}
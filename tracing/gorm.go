@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin 为每条 SQL 创建 span，通过 db.Use(tracing.GormPlugin{}) 注册
+// 调用方需要使用 db.WithContext(ctx)，否则 span 不会挂在请求的链路上
+type GormPlugin struct{}
+
+var _ gorm.Plugin = GormPlugin{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("tracing:before_create", startGormSpan("create")),
+		cb.Create().After("*").Register("tracing:after_create", endGormSpan),
+		cb.Query().Before("*").Register("tracing:before_query", startGormSpan("query")),
+		cb.Query().After("*").Register("tracing:after_query", endGormSpan),
+		cb.Update().Before("*").Register("tracing:before_update", startGormSpan("update")),
+		cb.Update().After("*").Register("tracing:after_update", endGormSpan),
+		cb.Delete().Before("*").Register("tracing:before_delete", startGormSpan("delete")),
+		cb.Delete().After("*").Register("tracing:after_delete", endGormSpan),
+		cb.Row().Before("*").Register("tracing:before_row", startGormSpan("row")),
+		cb.Row().After("*").Register("tracing:after_row", endGormSpan),
+		cb.Raw().Before("*").Register("tracing:before_raw", startGormSpan("raw")),
+		cb.Raw().After("*").Register("tracing:after_raw", endGormSpan),
+	)
+}
+
+func startGormSpan(op string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := Start(tx.Statement.Context, "mysql "+op, semconv.DBSystemMySQL, semconv.DBOperation(op))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endGormSpan(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	span.SetAttributes(
+		semconv.DBStatement(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil // 查不到记录是正常结果
+	}
+	End(span, err)
+}
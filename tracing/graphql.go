@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// GraphQLExtension 为 GraphQL 请求的解析、校验、执行三个阶段分别创建 span
+// 解析函数的 span 由调用方在解析函数中创建，这里不为每个字段创建 span，避免标量字段产生大量 span
+type GraphQLExtension struct{}
+
+var _ graphql.Extension = GraphQLExtension{}
+
+func (GraphQLExtension) Init(ctx context.Context, p *graphql.Params) context.Context {
+	return ctx
+}
+
+func (GraphQLExtension) Name() string {
+	return "tracing"
+}
+
+// ParseDidStart 返回原来的 ctx，校验和执行阶段的 span 与解析阶段平级
+func (GraphQLExtension) ParseDidStart(ctx context.Context) (context.Context, graphql.ParseFinishFunc) {
+	_, span := Start(ctx, "graphql.parse")
+	return ctx, func(err error) {
+		End(span, err)
+	}
+}
+
+func (GraphQLExtension) ValidationDidStart(ctx context.Context) (context.Context, graphql.ValidationFinishFunc) {
+	_, span := Start(ctx, "graphql.validate")
+	return ctx, func(errs []gqlerrors.FormattedError) {
+		End(span, joinErrors(errs))
+	}
+}
+
+// ExecutionDidStart 返回带有执行阶段 span 的 ctx，解析函数中的 span 都挂在执行阶段下
+func (GraphQLExtension) ExecutionDidStart(ctx context.Context) (context.Context, graphql.ExecutionFinishFunc) {
+	ctx, span := Start(ctx, "graphql.execute")
+	return ctx, func(result *graphql.Result) {
+		span.SetAttributes(attribute.Int("graphql.errors", len(result.Errors)))
+		End(span, joinErrors(result.Errors))
+	}
+}
+
+func (GraphQLExtension) ResolveFieldDidStart(ctx context.Context, info *graphql.ResolveInfo) (context.Context, graphql.ResolveFieldFinishFunc) {
+	return ctx, func(interface{}, error) {}
+}
+
+func (GraphQLExtension) HasResult() bool {
+	return false
+}
+
+func (GraphQLExtension) GetResult(context.Context) interface{} {
+	return nil
+}
+
+func joinErrors(errs []gqlerrors.FormattedError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	list := make([]error, 0, len(errs))
+	for _, e := range errs {
+		list = append(list, errors.New(e.Message))
+	}
+	return errors.Join(list...)
+}
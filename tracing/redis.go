@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// RedisHook 为每条 redis 命令创建 span，span 名称为命令名，例如 "redis DECR"
+type RedisHook struct{}
+
+var _ redis.Hook = RedisHook{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	attrs := []attribute.KeyValue{semconv.DBSystemRedis, semconv.DBOperation(cmd.Name())}
+	if args := cmd.Args(); len(args) > 1 {
+		if key, ok := args[1].(string); ok {
+			attrs = append(attrs, attribute.String("db.redis.key", key))
+		}
+	}
+	ctx, _ = Start(ctx, "redis "+strings.ToUpper(cmd.Name()), attrs...)
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	End(trace.SpanFromContext(ctx), redisErr(cmd.Err()))
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = Start(ctx, "redis pipeline", semconv.DBSystemRedis, attribute.Int("db.redis.num_cmd", len(cmds)))
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = redisErr(cmd.Err()); err != nil {
+			break
+		}
+	}
+	End(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// redisErr 键不存在是正常结果，不算失败
+func redisErr(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"github.com/graphql-go/graphql"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+// 测试一次 GraphQL 请求会产生解析、校验、执行三个 span，解析函数中的 span 挂在执行阶段下
+func TestGraphQLExtensionSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"hello": &graphql.Field{
+					Type: graphql.String,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						_, span := Start(p.Context, "resolve hello")
+						End(span, nil)
+						return "world", nil
+					},
+				},
+			},
+		}),
+		Extensions: []graphql.Extension{GraphQLExtension{}},
+	})
+	assert.NoError(t, err)
+
+	ctx, root := Start(context.Background(), "request")
+	result := graphql.Do(graphql.Params{Schema: schema, RequestString: "{ hello }", Context: ctx})
+	root.End()
+	assert.Empty(t, result.Errors)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	rootID := spans["request"].SpanContext().SpanID()
+	assert.Equal(t, rootID, spans["graphql.parse"].Parent().SpanID())
+	assert.Equal(t, rootID, spans["graphql.validate"].Parent().SpanID())
+	assert.Equal(t, rootID, spans["graphql.execute"].Parent().SpanID())
+	assert.Equal(t, spans["graphql.execute"].SpanContext().SpanID(), spans["resolve hello"].Parent().SpanID())
+}
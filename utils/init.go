@@ -2,78 +2,75 @@ package utils
 
 import (
 	"VoteMe/config"
+	"VoteMe/control"
 	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/logging"
 	"VoteMe/model"
 	"context"
+	"errors"
 	"fmt"
-	"math/rand"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync/atomic"
 	"time"
 )
 
-// Init 初始化
-func init() {
-	rand.Seed(time.Now().UnixNano())
-	// 收尾工作：让 redis 中缓存的投票数，能够刷盘；将redis中缓存的东西清除
-	go gracefulShutdown()
-	// 生成票据
-	go ticketGenerator()
-	// 数据库中的信息预存到 redis 中
-	getDbVotesToRedis()
-	// 将redis中的数据累加到mysql中
-	go syncVotesToDB()
-}
+// candidatesLoaded 候选人名单是否已经同步到 redis，用于就绪检查
+var candidatesLoaded atomic.Bool
 
-// GracefulShutdown 执行最后的收尾工作
-func gracefulShutdown() {
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	// 保证redis中新增投票能够刷盘
-	fmt.Println("VotesCacheToDb......")
-	time.Sleep(config.VotesCacheToDbTime)
-	// 所有工作完成后，将数据库缓存清除
-	fmt.Println("Clear the cache related to Voteme ......")
-	time.Sleep(time.Second)
-	err := deleteKeysByPattern("Voteme:*")
-	if err != nil {
-		fmt.Printf("Clear failed, err: %s\n", err)
-	}
-	fmt.Println("Clear the Ticket Table...")
-	time.Sleep(time.Second)
-	cleanTicketTable()
-	os.Exit(0)
+// CandidatesLoaded 返回候选人名单是否已经同步到 redis
+func CandidatesLoaded() bool {
+	return candidatesLoaded.Load()
 }
 
-// GetDbVotesToRedis 项目启动时，自动将数据库中的用户投票数据同步到Redis
-func getDbVotesToRedis() error {
+// LoadCandidates 项目启动时，自动将数据库中的用户名单同步到Redis
+// 使用 SETNX，其他实例尚未刷盘的投票数不会被覆盖
+func LoadCandidates(ctx context.Context) error {
 	var users []model.User
 
 	// 从数据库中查询所有用户的name和votes字段
-	if err := db.GetDB().Select("name").Find(&users).Error; err != nil {
+	if err := db.GetDB().WithContext(ctx).Select("name").Find(&users).Error; err != nil {
 		return err
 	}
 
-	ctx := context.Background()
-
 	// 遍历用户数据，将每个用户的投票数同步到Redis
+	names := make([]string, 0, len(users))
 	for _, user := range users {
+		names = append(names, user.Name)
 		// 使用用户的votes:name作为键，votes作为值
-		key := fmt.Sprintf("Voteme:votes:%s", user.Name)
-		if err := db.GetRedisCLi().Set(ctx, key, user.Votes, 0).Err(); err != nil {
+		key := keys.Votes(user.Name)
+		if err := db.GetRedisCLi().SetNX(ctx, key, user.Votes, 0).Err(); err != nil {
 			return fmt.Errorf("failed to set Redis key for user %s: %v", user.Name, err)
 		}
 	}
+	control.SetCandidates(names)
+	candidatesLoaded.Store(true)
 	return nil
 }
 
-func syncVotesToDB() {
-	ticker := time.NewTicker(config.VotesCacheToDbTime) // 每一定时间间隔刷盘一次
+// VotesFlusher 每隔 VotesCacheToDbTime 将redis中的数据累加到mysql中，ctx 取消后退出
+// 退出时不会做最后一次刷盘，由调用方在停止接收请求后调用 SyncVotes
+func VotesFlusher(ctx context.Context) error {
+	interval := config.Current().VotesCacheToDbTime
+	ticker := time.NewTicker(interval) // 每一定时间间隔刷盘一次
 	defer ticker.Stop()
+	changes := config.Notify(ctx) // 热更后刷盘间隔立即生效
 
-	for range ticker.C {
-		syncVotes()
+	for {
+		select {
+		case <-ticker.C:
+			if err := SyncVotes(ctx); errors.Is(err, ErrSyncBusy) {
+				// 其他实例刷盘太久，下次再刷
+				logging.FromContext(ctx).WithError(err).Warn("skip sync votes")
+			} else if err != nil {
+				return err
+			}
+		case s := <-changes:
+			if s.VotesCacheToDbTime != interval {
+				interval = s.VotesCacheToDbTime
+				ticker.Reset(interval)
+			}
+		case <-ctx.Done():
+			return nil
+		}
 	}
 }
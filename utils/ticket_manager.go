@@ -4,13 +4,23 @@ import (
 	"VoteMe/config"
 	"VoteMe/control"
 	"VoteMe/db"
+	"VoteMe/keys"
+	"VoteMe/ledger"
+	"VoteMe/logging"
+	"VoteMe/metrics"
 	"VoteMe/model"
+	"VoteMe/tickets"
+	"VoteMe/tracing"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"log"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
 	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -20,82 +30,70 @@ var (
 	ticketMutex   sync.Mutex // ticketMutex是一个互斥锁，用于控制对currentTicket变量的并发访问
 )
 
-func cleanTicketTable() {
-	err := db.GetDB().Exec("TRUNCATE TABLE tickets").Error
-	if err != nil {
-		log.Fatalf("Failed to truncate table: %v", err)
+// TicketGenerator 是一个票据生成器，每隔 TicketsUpdateTime 生成一个新的随机票据
+// 出错时返回错误由调用方决定是否重启，ctx 取消后正常退出
+func TicketGenerator(ctx context.Context) error {
+	if err := RotateTicket(ctx); err != nil {
+		return err
 	}
-}
-
-// DeleteKeysByPattern 删除redis中前缀符合pattern的键值对
-func deleteKeysByPattern(pattern string) error {
-	var cursor uint64
-	var err error
-	for {
-		var keys []string
-		keys, cursor, err = db.GetRedisCLi().Scan(context.Background(), cursor, pattern, 0).Result()
-		if err != nil {
-			return err
-		}
-		if len(keys) > 0 {
-			_, err = db.GetRedisCLi().Del(context.Background(), keys...).Result()
-			if err != nil {
-				return err
+	interval := config.Current().TicketsUpdateTime
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	changes := config.Notify(ctx) // 热更后票据更新时间立即生效
+	// 过期后，在这里重新生成票据
+	for { // 循环监听定时器的通道
+		select {
+		case <-ticker.C:
+		case s := <-changes:
+			if s.TicketsUpdateTime == interval {
+				continue
 			}
+			// 旧票据的过期时间是按旧配置设置的，立即换发一张新票据，避免票据提前过期或迟迟不更新
+			interval = s.TicketsUpdateTime
+			ticker.Reset(interval)
+		case <-ctx.Done():
+			return nil
 		}
-		if cursor == 0 { // 如果cursor为0，表示迭代完成
-			break
+		if err := RotateTicket(ctx); err != nil {
+			return err
 		}
 	}
-	return nil
 }
 
-// ticketGenerator是一个票据生成器，每20秒生成一个新的随机票据
-// todo 这里为了方便测试，设置了20秒，后续改为需求中的2s
-func ticketGenerator() {
-	var err error
-	currentTicket, err = generateRandomHash(config.TicketLen)
+// RotateTicket 生成新票据，写入 redis 和 mysql 后替换当前票据
+// 管理接口也会调用它立即换发票据，旧票据在 redis 中仍然有效直到过期
+func RotateTicket(ctx context.Context) error {
+	settings := config.Current() // 同一张票据使用同一份配置
+	ticket, err := generateRandomHash(settings.TicketLen)
 	if err != nil {
-		log.Fatalf("GenerateRandomHash failed %s", err)
-
+		return fmt.Errorf("generateRandomHash failed: %w", err)
 	}
-	ticker := time.NewTicker(config.TicketsUpdateTime)
-
 	// 将当前有效票据写入 redis
-	err = control.SetValidateTicket(currentTicket, config.MaxVotes, config.TicketsUpdateTime)
+	err = control.SetValidateTicket(ctx, ticket, settings.MaxVotes, settings.TicketsUpdateTime)
 	if err != nil {
-		log.Fatalf("createTicket to redis failed %s", err)
+		return fmt.Errorf("createTicket to redis failed: %w", err)
 	}
-	//将当前有效的票据写入 mysql
-	err = control.CreateOrTicket(currentTicket)
+	// 将当前有效的票据写入 mysql，过期后再补全使用次数
+	err = tickets.Record(ctx, ticket, settings.MaxVotes, settings.TicketsUpdateTime)
 	if err != nil {
-		log.Fatalf("createTicket to mysql failed %s", err)
+		return fmt.Errorf("createTicket to mysql failed: %w", err)
 	}
-	// 过期后，在这里重新生成票据
-	for range ticker.C { // 循环监听定时器的通道
-		ticketMutex.Lock()                                        // 在修改 currentTicket 之前加锁
-		currentTicket, err = generateRandomHash(config.TicketLen) // 生成一个长度为10的随机字符串作为新票据
-		if err != nil {
-			log.Fatalf("GenerateRandomHash failed：%s", err)
-		}
-		// 将当前有效票据写入 redis
-		err = control.SetValidateTicket(currentTicket, config.MaxVotes, config.TicketsUpdateTime)
-		if err != nil {
-			log.Fatalf("createTicket to redis failed %s", err)
-		}
-		//// 将当前有效的票据写入 mysql
-		err = control.CreateOrTicket(currentTicket)
-		if err != nil {
-			log.Fatalf("createTicket to mysql failed %s", err)
-		}
-
-		ticketMutex.Unlock() // 修改完成后解锁
+	ticketMutex.Lock() // 在修改 currentTicket 之前加锁
+	currentTicket = ticket
+	ticketMutex.Unlock() // 修改完成后解锁
+	metrics.TicketRotations.Inc()
+	metrics.TicketRemainingUses.Set(float64(settings.MaxVotes))
+	logging.FromContext(ctx).Debugf("ticket rotated, expires in %s", settings.TicketsUpdateTime)
+	// 把已过期票据的使用次数从 redis 复制到 mysql，失败时下次换发再补
+	if _, err := tickets.FinalizeExpired(ctx); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("finalize expired tickets failed")
 	}
+	return nil
 }
 
 func generateRandomHash(n int) (string, error) {
-	// 生成足够的随机字节，由于转换成16进制后长度会翻倍，所以这里除以2
-	bytes := make([]byte, n/2)
+	// 生成足够的随机字节，由于转换成16进制后长度会翻倍，所以这里除以2并向上取整
+	bytes := make([]byte, (n+1)/2)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err // 在随机字节生成过程中返回错误
 	}
@@ -123,77 +121,210 @@ func generateRandomHash(n int) (string, error) {
 
 // GetCurrentTicket GetCurrentTicket函数返回当前有效的票据
 func GetCurrentTicket() string {
+	ticketMutex.Lock()
+	defer ticketMutex.Unlock()
 	return currentTicket // 返回当前有效的票据
 }
 
-// 将redis中的票数同步到数据库中
-func syncVotes() {
+// ErrSyncBusy 其他实例正在刷盘，等到超时也没有拿到刷盘锁
+var ErrSyncBusy = errors.New("another instance is syncing votes")
+
+// syncMu 同一个实例内的刷盘（定时刷盘、管理接口、结果认证、退出前的最后一次刷盘）依次执行
+var syncMu sync.Mutex
+
+// claimVotesScript 原子地取出选手尚未刷盘的增量并从计数器中扣掉，返回取出的票数
+// 并发的投票在扣减之后继续累加，不会丢失，也不会被两次刷盘重复取出
+var claimVotesScript = redis.NewScript(`
+local votes = tonumber(redis.call("GET", KEYS[1]) or "0")
+if votes ~= 0 then
+	redis.call("DECRBY", KEYS[1], votes)
+end
+return votes
+`)
+
+// releaseSyncLockScript 只释放自己持有的刷盘锁
+var releaseSyncLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// acquireSyncLock 获取刷盘锁，其他实例持有时等待到 ctx 超时，返回释放锁的函数
+// 锁的有效期是刷盘超时时间的两倍，持有锁的实例崩溃后锁会自动过期
+func acquireSyncLock(ctx context.Context) (func(), error) {
+	token, err := generateRandomHash(32)
+	if err != nil {
+		return nil, err
+	}
+	ttl := 2 * config.Current().SyncVotesTimeout
+	for {
+		lockCtx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+		ok, err := db.GetRedisCLi().SetNX(lockCtx, keys.SyncLock(), token, ttl).Result()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("acquire sync lock: %w", err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(rand.Intn(50)+50) * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ErrSyncBusy
+		}
+	}
+	return func() {
+		// 即使 ctx 已取消也要释放锁
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), config.Current().RedisTimeout)
+		defer cancel()
+		if err := releaseSyncLockScript.Run(releaseCtx, db.GetRedisCLi(), []string{keys.SyncLock()}, token).Err(); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("release sync lock failed, it will expire by itself")
+		}
+	}, nil
+}
+
+// SyncVotes 将redis中的票数同步到数据库中
+// 实例内用互斥锁、实例之间用 redis 锁保证同一时间只有一次刷盘；每个选手的增量先从 redis 中原子地取出再写入 mysql，
+// 写入失败时把增量加回 redis，下次刷盘时重试，单个选手失败不会中断整个批次
+// 整个批次受 timeout.syncVotes 限制，超时后剩余选手留到下次刷盘；拿不到刷盘锁时返回 ErrSyncBusy
+func SyncVotes(ctx context.Context) error {
+	return SyncThen(ctx, nil)
+}
+
+// SyncThen 执行一次刷盘，然后在仍然持有刷盘锁时执行 fn，fn 执行期间不会有其他刷盘修改 users.votes
+// 用于结果认证等需要在刷盘后读取一致快照的场景，fn 应尽快返回，刷盘锁的有效期是刷盘超时时间的两倍
+func SyncThen(ctx context.Context, fn func(ctx context.Context) error) error {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	lockCtx, cancel := context.WithTimeout(ctx, config.Current().SyncVotesTimeout)
+	unlock, err := acquireSyncLock(lockCtx)
+	cancel()
+	if err != nil {
+		metrics.SyncErrors.WithLabelValues("lock").Inc()
+		return err
+	}
+	defer unlock()
+	if err := syncVotes(ctx); err != nil {
+		return err
+	}
+	if fn == nil {
+		return nil
+	}
+	return fn(ctx)
+}
+
+// syncVotes 逐个选手刷盘，调用方需要持有刷盘锁
+func syncVotes(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	start := time.Now()
+	defer func() { metrics.SyncDuration.Observe(time.Since(start).Seconds()) }()
+	ctx, span := tracing.Start(ctx, "syncVotes")
+	ctx, cancel := context.WithTimeout(ctx, config.Current().SyncVotesTimeout)
+	defer cancel()
 	// 获取所有需要同步的用户名列表
-	userNames, err := getAllUserNames()
+	userNames, err := getAllUserNames(ctx)
 	if err != nil {
-		log.Fatalf("getAllUserNames failed")
+		metrics.SyncErrors.WithLabelValues("list_users").Inc()
+		tracing.End(span, err)
+		return fmt.Errorf("getAllUserNames failed: %w", err)
 	}
+	failed := false // 有选手刷盘失败时，不算一次成功的刷盘
+	pending := 0    // 本次待刷盘的票数
 	// 将 redis 中的 votes 逐个刷入mysql
 	for _, userName := range userNames {
-		key := fmt.Sprintf("Voteme:votes:%s", userName)
-		votes, err := db.GetRedisCLi().Get(context.Background(), key).Int()
-		if votes == 0 {
-			continue
+		if ctx.Err() != nil {
+			logger.WithError(ctx.Err()).Warn("sync votes interrupted, remaining votes will be flushed next time")
+			metrics.SyncErrors.WithLabelValues("timeout").Inc()
+			failed = true
+			break
 		}
-		if err == redis.Nil {
-			continue
-		} else if err != nil {
+		key := keys.Votes(userName)
+		votes, err := claimVotesScript.Run(ctx, db.GetRedisCLi(), []string{key}).Int()
+		if err != nil {
 			// 处理错误
-			fmt.Println("Error getting votes from Redis:", err)
+			logger.WithError(err).WithField("user", userName).Error("claim votes from redis failed")
+			metrics.SyncErrors.WithLabelValues("redis_claim").Inc()
+			failed = true
 			continue
 		}
+		if votes == 0 {
+			continue
+		}
+		pending += votes
 
-		// 在这里更新数据库中的票数
-		// 开启一个事务
-		//tx := db.GetDB().Begin()
-		//
-		//// 尝试锁定特定用户的记录
-		//var votesCount int
-		//err = tx.Raw("SELECT votes FROM users WHERE name = ? FOR UPDATE", userName).Scan(&votesCount).Error
-		//if err != nil {
-		//	tx.Rollback() // 如果出现错误，回滚事务
-		//	fmt.Println("Locking failed during disk brushing:", err)
-		//}
-		//
-		//// 执行更新操作
-		//err = tx.Exec("UPDATE users SET votes = votes + ? WHERE name = ?", votes, userName).Error
-		//if err != nil {
-		//	tx.Rollback() // 如果更新失败，回滚事务
-		//	fmt.Println("Failed to update when brushing the disc：", err)
-		//}
-		//
-		//// 提交事务
-		//err = tx.Commit().Error
-		//if err != nil {
-		//	fmt.Println("Failed to submit transaction when brushing disk：", err)
-		//
-		//}
-
-		err = db.GetDB().Exec("UPDATE users SET votes = votes +  ? WHERE name = ?", votes, userName).Error
+		if ledger.Enabled() {
+			// 增量和账本记录在同一个事务中写入
+			_, err = ledger.Record(ctx, userName, int64(votes), func(tx *gorm.DB) error {
+				return tx.Exec("UPDATE users SET votes = votes + ? WHERE name = ?", votes, userName).Error
+			})
+		} else {
+			err = db.GetDB().WithContext(ctx).Exec("UPDATE users SET votes = votes +  ? WHERE name = ?", votes, userName).Error
+		}
 		if err != nil {
 			// 处理错误
-			fmt.Println("Error updating votes in DB:", err)
-			continue
+			logger.WithError(err).WithField("user", userName).Error("update votes in mysql failed")
+			metrics.SyncErrors.WithLabelValues("mysql_update").Inc()
+			failed = true
+			// 增量没有写入 mysql，加回 redis 留到下次刷盘，即使 ctx 已取消也要加回
+			restoreCtx, restoreCancel := context.WithTimeout(context.WithoutCancel(ctx), config.Current().RedisTimeout)
+			err = db.GetRedisCLi().IncrBy(restoreCtx, key, int64(votes)).Err()
+			restoreCancel()
+			if err != nil {
+				// 增量既没有写入 mysql 也没有加回 redis，需要按日志人工补票
+				logger.WithError(err).WithFields(log.Fields{"user": userName, "votes": votes}).
+					Error("votes were claimed but could not be restored to redis, votes are lost")
+				metrics.SyncErrors.WithLabelValues("redis_restore").Inc()
+			}
 		}
+	}
+	metrics.SyncPendingDelta.Set(float64(pending))
+	span.SetAttributes(attribute.Int("sync.users", len(userNames)), attribute.Int("sync.pending", pending),
+		attribute.Bool("sync.failed", failed))
+	tracing.End(span, nil)
+	logger.WithField("pending", pending).Debug("votes synced")
+	if !failed {
+		metrics.MarkSynced(time.Now())
+	}
+	return nil
+}
 
-		// 同步成功后，重置Redis中的计数器
-		err = db.GetRedisCLi().DecrBy(context.Background(), key, int64(votes)).Err()
+// PendingVotes 返回 redis 中尚未刷入 mysql 的票数合计
+func PendingVotes(ctx context.Context) (int64, error) {
+	userNames, err := getAllUserNames(ctx)
+	if err != nil || len(userNames) == 0 {
+		return 0, err
+	}
+	voteKeys := make([]string, len(userNames))
+	for i, name := range userNames {
+		voteKeys[i] = keys.Votes(name)
+	}
+	redisCtx, cancel := context.WithTimeout(ctx, config.Current().RedisTimeout)
+	defer cancel()
+	values, err := db.GetRedisCLi().MGet(redisCtx, voteKeys...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var pending int64
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue // 键不存在
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
-
+			return 0, fmt.Errorf("invalid votes for user %s: %w", userNames[i], err)
 		}
+		pending += n
 	}
+	return pending, nil
 }
 
 // 获取数据库中所有名字
-func getAllUserNames() ([]string, error) {
+func getAllUserNames(ctx context.Context) ([]string, error) {
 	var userNames []string
 
-	if err := db.GetDB().Model(&model.User{}).Select("name").Find(&userNames).Error; err != nil {
+	if err := db.GetDB().WithContext(ctx).Model(&model.User{}).Select("name").Find(&userNames).Error; err != nil {
 		return nil, err
 	}
 